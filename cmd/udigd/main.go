@@ -1,13 +1,18 @@
 package main // imports "github.com/mkmik/udig/cmd/udigd"
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	// registers debug handlers
@@ -19,37 +24,166 @@ import (
 	"github.com/hashicorp/yamux"
 	cid "github.com/ipfs/go-cid"
 	"github.com/mkmik/stringlist"
+	"github.com/mkmik/udig/pkg/admin"
+	"github.com/mkmik/udig/pkg/admin/adminpb"
+	"github.com/mkmik/udig/pkg/authz"
+	"github.com/mkmik/udig/pkg/certs"
 	"github.com/mkmik/udig/pkg/ingress"
+	"github.com/mkmik/udig/pkg/nameserver"
+	"github.com/mkmik/udig/pkg/placement"
+	"github.com/mkmik/udig/pkg/proxyproto"
 	"github.com/mkmik/udig/pkg/tunnel/tunnelpb"
 	"github.com/mkmik/udig/pkg/uplink"
 	"github.com/mkmik/udig/pkg/uplink/uplinkpb"
+	"github.com/mkmik/udig/pkg/webhook"
 	multibase "github.com/multiformats/go-multibase"
 	"github.com/multiformats/go-multihash"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	forwarded "github.com/stanvit/go-forwarded"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/net/trace"
+	"golang.org/x/net/websocket"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 const (
 	// Ed25519Pub should live in multihash
 	Ed25519Pub = 0xed
+
+	// hostnameVerifyTimeout bounds the DNS lookup verifying a custom host name.
+	hostnameVerifyTimeout = 10 * time.Second
+
+	// webhookBuffer is the number of events queued for each webhook while earlier ones are delivered.
+	webhookBuffer = 1024
 )
 
+// defaultWebhookEvents are the event types posted to webhooks unless -webhook-events is given;
+// stream-opened is left out since busy tunnels emit it for every client connection.
+var defaultWebhookEvents = []uplink.EventType{uplink.TunnelRegistered, uplink.TunnelRemoved, uplink.UplinkAdded}
+
 var (
 	uaddr  = flag.String("uplink", ":4000", "uplink callback listening address:port")
 	haddr  = flag.String("http", "", "debug/metrics http server listening address:port")
 	domain = flag.String("domain", "udig.io", "domain name used for ingress adresses")
+	daddr  = flag.String("dns", "", "authoritative DNS server listening address:port for the ingress domain")
+
+	publicIPs = stringlist.Flag("public-ip", "public IP address(es) of the broker, returned by the DNS server; comma separated or repeated flag")
 
-	ports = stringlist.Flag("port", "enabled ingress port(s); comma separated or repeated flag)")
+	ports            = stringlist.Flag("port", "enabled ingress port(s); comma separated or repeated flag)")
+	passthroughPorts = stringlist.Flag("passthrough-port", "enabled TLS passthrough ingress port(s); comma separated or repeated flag)")
+
+	httpPorts    = stringlist.Flag("http-ingress-port", "enabled plaintext HTTP ingress port(s), routed on the Host header; comma separated or repeated flag")
+	httpRedirect = flag.Bool("http-ingress-redirect", false, "redirect plaintext HTTP ingress requests to https (except ACME HTTP-01 challenges)")
+
+	proxyProtocolPorts   = stringlist.Flag("proxy-protocol-port", "ingress port(s) accepting a PROXY protocol header (v1 or v2); comma separated or repeated flag")
+	proxyProtocolTrusted = stringlist.Flag("proxy-protocol-trusted", "networks (CIDR) allowed to send a PROXY protocol header, e.g. the load balancer; comma separated or repeated flag")
 
 	certPath = flag.String("cert", "", "path to PEM encoded x509 certificate for ingress server")
 	keyPath  = flag.String("key", "", "path to PEM encoded private key for ingress server")
+
+	acmeDirectory = flag.String("acme-directory", "", "ACME directory URL; if set, the ingress certificate for *.<domain> is obtained via DNS-01 using the built-in DNS server instead of -cert/-key")
+	acmeEmail     = flag.String("acme-email", "", "contact email for the ACME account")
+	acmeCache     = flag.String("acme-cache", "acme", "directory where the ACME account key and certificate are stored")
+	acmeCA        = flag.String("acme-ca", "", "path to PEM encoded CA certificate(s) trusted when talking to the ACME server (e.g. for Pebble)")
+
+	uplinkWebSocketAddr = flag.String("uplink-websocket", "", "listening address:port for uplinks tunneled over WebSocket (path "+uplink.WebSocketPath+"), e.g. :443 on a dedicated IP")
+	uplinkTLS           = flag.Bool("uplink-tls", true, "require TLS on uplink connections")
+	uplinkCertPath      = flag.String("uplink-cert", "", "path to PEM encoded x509 certificate for the uplink listener (default: the ingress certificate)")
+	uplinkKeyPath       = flag.String("uplink-key", "", "path to PEM encoded private key for the uplink listener (default: the ingress key)")
+
+	shard  = flag.String("shard", "", "uplink address of this broker instance, as listed in -shards")
+	shards = stringlist.Flag("shards", "uplink addresses (host:port or wss:// URL) of all the broker instances sharing the domain; tunnels are spread among them by consistent hashing and uplinks reaching the wrong instance are redirected; comma separated or repeated flag")
+
+	balancer = flag.String("balancer", uplink.DefaultBalancer, "strategy spreading the streams of a tunnel among its uplinks, unless the tunnel asks for another one: round-robin, least-active, two-choices, source-hash or random")
+
+	graceWindow = flag.Duration("grace-window", 10*time.Second, "how long new connections to a tunnel that just lost its last uplink are held while waiting for it to reconnect (0: close them right away)")
+
+	adminTokenFile = flag.String("admin-token-file", "", "path to a file holding the token required by the admin API; if set, the admin API is served on the -http server under "+admin.PathPrefix)
+	adminGRPCAddr  = flag.String("admin-grpc", "", "listening address:port for the admin gRPC API (requires -admin-token-file)")
+
+	webhooks          = stringlist.Flag("webhook", "URL(s) receiving tunnel lifecycle events as JSON POST requests; comma separated or repeated flag")
+	webhookEvents     = stringlist.Flag("webhook-events", "event types posted to the -webhook URLs: tunnel-registered, tunnel-removed, uplink-added or stream-opened; comma separated or repeated flag (default: all but stream-opened)")
+	webhookSecretFile = flag.String("webhook-secret-file", "", "path to a file holding the secret used to sign webhook requests (HMAC-SHA256 in the "+webhook.SignatureHeader+" header)")
+
+	authzFile = flag.String("authz-file", "", "path to the authorization policy file listing the tunnel IDs or public keys allowed or denied to register, and their allowed ports; reloaded on change (default: allow all)")
+	tokenKeys = flag.String("token-keys", "", "path to a file with the base64 encoded operator public keys, one per line; if set, tunnels must present an invite token signed by one of them (see the mint-token subcommand)")
+
+	hostnameCertCache = flag.String("hostname-cert-cache", "hostname-certs", "directory where ACME certificates for custom host names are stored; they're obtained from -acme-directory (default Let's Encrypt)")
 )
 
-func handleUplink(ctx context.Context, conn *grpc.ClientConn, domain string, enabledPorts []int32, changeUplink chan<- uplink.Change) (err error) {
+// portConfig holds the enabled ingress ports, by ingress mode.
+type portConfig struct {
+	tls         []int32 // TLS is terminated by the broker
+	passthrough []int32 // TLS is passed through to the uplink
+	http        []int32 // plaintext HTTP
+
+	proxyProtocol        map[int32]bool // ports accepting PROXY protocol headers
+	proxyProtocolTrusted []*net.IPNet   // networks allowed to send PROXY protocol headers
+}
+
+// proxyTrusted returns the networks allowed to send PROXY protocol headers on a given port.
+func (p portConfig) proxyTrusted(port int32) []*net.IPNet {
+	if !p.proxyProtocol[port] {
+		return nil
+	}
+	return p.proxyProtocolTrusted
+}
+
+// forRequest returns the enabled ports matching the mode requested by a tunnel client.
+func (p portConfig) forRequest(req *uplinkpb.RegisterRequest) []int32 {
+	if req.TlsPassthrough {
+		return p.passthrough
+	}
+	return append(append([]int32(nil), p.tls...), p.http...)
+}
+
+// uplinkConfig holds the broker settings used to handle uplink connections.
+type uplinkConfig struct {
+	domain       string
+	enabledPorts portConfig
+	// placement, if not nil, redirects tunnels that belong to other broker instances.
+	placement    placement.Policy
+	authorizer   authz.Authorizer
+	uplinks      *uplinkCounter
+	changeUplink chan<- uplink.Change
+}
+
+// uplinkCounter counts the registered uplinks of each tunnel, to enforce authz.Grant.MaxUplinks.
+type uplinkCounter struct {
+	mu sync.Mutex
+	n  map[string]int
+}
+
+func newUplinkCounter() *uplinkCounter {
+	return &uplinkCounter{n: map[string]int{}}
+}
+
+// acquire counts a new uplink for a tunnel, unless the tunnel already has max uplinks (zero means no limit).
+func (c *uplinkCounter) acquire(tunnelID string, max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if max > 0 && c.n[tunnelID] >= max {
+		return false
+	}
+	c.n[tunnelID]++
+	return true
+}
+
+// release uncounts an uplink that went away.
+func (c *uplinkCounter) release(tunnelID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.n[tunnelID]--; c.n[tunnelID] <= 0 {
+		delete(c.n, tunnelID)
+	}
+}
+
+// handleUplink registers the tunnel of an uplink connection coming from remoteAddr; disconnect forcibly closes the connection.
+func handleUplink(ctx context.Context, conn *grpc.ClientConn, remoteAddr string, disconnect func(), cfg uplinkConfig) (err error) {
 	defer conn.Close()
 
 	up := uplinkpb.NewUplinkClient(conn)
@@ -88,15 +222,58 @@ func handleUplink(ctx context.Context, conn *grpc.ClientConn, domain string, ena
 	}
 	glog.V(2).Infof("signature ok")
 
+	if b := req.GetBalancer(); b != "" {
+		if _, err := uplink.NewBalancer(b); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
 	tid, err := mkTunnelID(req.Ed25519PublicKey)
 	if err != nil {
 		return err
 	}
 	glog.Infof("setting up uplink for tunnel %s", tid)
 
+	grant, err := cfg.authorizer.Authorize(authz.Request{PublicKey: req.Ed25519PublicKey, TunnelID: tid, Token: req.GetToken()})
+	if err != nil {
+		glog.Infof("rejecting tunnel %s: %v", tid, err)
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if cfg.placement != nil {
+		if to := cfg.placement.Redirect(tid); len(to) > 0 {
+			glog.Infof("redirecting uplink for tunnel %s to %q", tid, to)
+			_, err := up.Setup(ctx, &uplinkpb.SetupRequest{
+				Setup: &uplinkpb.SetupRequest_Redirect_{
+					Redirect: &uplinkpb.SetupRequest_Redirect{
+						RedirectTo: to,
+					},
+				},
+			})
+			return err
+		}
+	}
+
+	hostnames := verifyHostnames(ctx, req.Ed25519PublicKey, req.Hostnames)
+
+	ports, err := uplinkPorts(tid, req.Ports, cfg.enabledPorts.forRequest(req), grant)
+	if err != nil {
+		glog.Infof("rejecting tunnel %s: %v", tid, err)
+		return err
+	}
+
+	if !cfg.uplinks.acquire(tid, grant.MaxUplinks) {
+		// not permanent: the uplink retries with backoff until another one of the tunnel goes away.
+		return status.Errorf(codes.ResourceExhausted, "tunnel %s already has the maximum of %d uplinks", tid, grant.MaxUplinks)
+	}
+	defer cfg.uplinks.release(tid)
+
 	var ins []string
-	for _, port := range effectivePorts(req.Ports, enabledPorts) {
-		ins = append(ins, fmt.Sprintf("%s.%s:%d", tid, domain, port))
+	for _, port := range ports {
+		ins = append(ins, fmt.Sprintf("%s.%s:%d", tid, cfg.domain, port))
+		for _, h := range hostnames {
+			ins = append(ins, fmt.Sprintf("%s:%d", h, port))
+		}
 	}
 
 	_, err = up.Setup(ctx, &uplinkpb.SetupRequest{
@@ -110,15 +287,22 @@ func handleUplink(ctx context.Context, conn *grpc.ClientConn, domain string, ena
 		return err
 	}
 
-	changeUplink <- uplink.Change{
-		TunnelID: tid,
-		UplinkID: conn.Target(),
-		Client:   tunnelpb.NewTunnelClient(conn),
+	cfg.changeUplink <- uplink.Change{
+		TunnelID:    tid,
+		UplinkID:    conn.Target(),
+		Client:      tunnelpb.NewTunnelClient(conn),
+		Passthrough: req.TlsPassthrough,
+		Hostnames:   hostnames,
+		Balancer:    req.GetBalancer(),
+		RemoteAddr:  remoteAddr,
+		Close:       disconnect,
+		Ingress:     ins,
+		Ports:       ports,
 	}
 
 	<-ctx.Done()
 
-	changeUplink <- uplink.Change{
+	cfg.changeUplink <- uplink.Change{
 		TunnelID: tid,
 		UplinkID: conn.Target(),
 		Client:   nil,
@@ -127,6 +311,23 @@ func handleUplink(ctx context.Context, conn *grpc.ClientConn, domain string, ena
 	return nil
 }
 
+// verifyHostnames returns the custom host names whose ownership by the tunnel key is proven via DNS.
+func verifyHostnames(ctx context.Context, pub ed25519.PublicKey, hostnames []string) []string {
+	var res []string
+	for _, h := range hostnames {
+		ctx, cancel := context.WithTimeout(ctx, hostnameVerifyTimeout)
+		err := uplink.VerifyHostname(ctx, net.DefaultResolver, pub, h)
+		cancel()
+		if err != nil {
+			glog.Errorf("cannot verify custom host name %q: %v", h, err)
+			continue
+		}
+		glog.Infof("verified custom host name %q", h)
+		res = append(res, strings.ToLower(h))
+	}
+	return res
+}
+
 func effectivePorts(requestedPorts, enabledPorts []int32) []int32 {
 	rpm := map[int32]bool{}
 	for _, port := range requestedPorts {
@@ -142,6 +343,29 @@ func effectivePorts(requestedPorts, enabledPorts []int32) []int32 {
 	return res
 }
 
+// uplinkPorts returns the ingress ports whose streams an uplink receives: the requested ones (all if none)
+// among the enabled and granted ones. An uplink left with no ports is rejected rather than registered,
+// since the router would take an empty port list as any port.
+func uplinkPorts(tid string, requestedPorts, enabledPorts []int32, grant authz.Grant) ([]int32, error) {
+	ports := effectivePorts(requestedPorts, enabledPorts)
+	if len(ports) == 0 {
+		if len(requestedPorts) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "no ingress ports are enabled for tunnel %s", tid)
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "none of the requested ports %v is enabled for tunnel %s", requestedPorts, tid)
+	}
+	if grant.Ports != nil {
+		// effectivePorts treats an empty list as all ports, while an empty grant allows none.
+		if len(grant.Ports) > 0 {
+			ports = effectivePorts(grant.Ports, ports)
+		}
+		if len(grant.Ports) == 0 || len(ports) == 0 {
+			return nil, status.Errorf(codes.PermissionDenied, "tunnel %s is not allowed on the requested ports", tid)
+		}
+	}
+	return ports, nil
+}
+
 func mkTunnelID(publicKey []byte) (string, error) {
 	mh, err := multihash.Sum(publicKey, multihash.SHA2_256, -1)
 	if err != nil {
@@ -165,12 +389,16 @@ func randomUplinkID() (string, error) {
 	return c.Encode(multibase.MustNewEncoder(multibase.Base32)), nil
 }
 
-func listenUplink(uaddr, domain string, enabledPorts []int32, changeUplink chan<- uplink.Change) {
+// listenUplink accepts uplink connections on uaddr. If tlsConfig is not nil, uplink connections are wrapped in TLS.
+func listenUplink(uaddr string, tlsConfig *tls.Config, cfg uplinkConfig) {
 	lis, err := net.Listen("tcp", uaddr)
 	if err != nil {
 		glog.Fatalf("could not listen: %v", err)
 	}
 	defer lis.Close()
+	if tlsConfig != nil {
+		lis = tls.NewListener(lis, tlsConfig)
+	}
 
 	glog.Infof("waiting for uplinks")
 	for {
@@ -178,43 +406,92 @@ func listenUplink(uaddr, domain string, enabledPorts []int32, changeUplink chan<
 		if err != nil {
 			glog.Fatalf("couldn't accept %v", err)
 		}
-		incomingConn, err := yamux.Client(incoming, yamux.DefaultConfig())
-		if err != nil {
-			glog.Fatalf("couldn't create yamux %v", err)
-		}
+		go func() {
+			if err := serveUplink(incoming, incoming.RemoteAddr().String(), cfg); err != nil {
+				glog.Errorf("%+v", err)
+			}
+		}()
+	}
+}
 
-		uplinkID, err := randomUplinkID()
-		if err != nil {
-			glog.Fatalf("%+v", err)
-		}
+// listenUplinkWebSocket accepts uplink connections tunneled in WebSocket upgrades of HTTPS requests
+// to uplink.WebSocketPath on waddr, for clients that can only reach the broker on HTTPS.
+func listenUplinkWebSocket(waddr string, tlsConfig *tls.Config, cfg uplinkConfig) {
+	glog.Infof("waiting for websocket uplinks on %s", waddr)
+
+	mux := http.NewServeMux()
+	mux.Handle(uplink.WebSocketPath, websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			// the peer address comes from the request: the RemoteAddr of a server side websocket.Conn
+			// is the (absent) origin of the client.
+			if err := serveUplink(ws, ws.Request().RemoteAddr, cfg); err != nil {
+				glog.Errorf("%+v", err)
+			}
+		},
+	})
+	srv := &http.Server{Addr: waddr, Handler: mux, TLSConfig: tlsConfig}
+	var err error
+	if tlsConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	glog.Fatalf("could not serve websocket uplinks: %v", err)
+}
 
-		conn, err := grpc.Dial(uplinkID, grpc.WithInsecure(),
-			grpc.WithDialer(func(target string, timeout time.Duration) (net.Conn, error) {
-				return incomingConn.Open()
-			}),
-		)
-		if err != nil {
-			glog.Fatalf("did not connect: %s", err)
-		}
+// serveUplink runs the reverse gRPC uplink protocol over an incoming uplink connection
+// from remoteAddr, and blocks until the connection goes away.
+func serveUplink(incoming net.Conn, remoteAddr string, cfg uplinkConfig) error {
+	incomingConn, err := yamux.Client(incoming, yamux.DefaultConfig())
+	if err != nil {
+		return fmt.Errorf("couldn't create yamux: %w", err)
+	}
+	defer incomingConn.Close()
 
-		ctx, cancel := context.WithCancel(context.Background())
-		go func() {
-			<-incomingConn.CloseChan()
-			cancel()
-		}()
+	uplinkID, err := randomUplinkID()
+	if err != nil {
+		return err
+	}
 
-		// handleUplink now doesn't have to be aware of the underlying transport contortions
-		// and can work with a high level grpc connection. When the underlying connection goes away
-		// the context will be canceled.
+	conn, err := grpc.Dial(uplinkID, grpc.WithInsecure(),
+		grpc.WithDialer(func(target string, timeout time.Duration) (net.Conn, error) {
+			return incomingConn.Open()
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("did not connect: %w", err)
+	}
 
-		go func() {
-			glog.Infof("Handling uplink from %q", incoming.RemoteAddr())
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-incomingConn.CloseChan()
+		cancel()
+	}()
 
-			if err := handleUplink(ctx, conn, domain, enabledPorts, changeUplink); err != nil {
-				glog.Errorf("%+v", err)
-			}
-		}()
+	// handleUplink now doesn't have to be aware of the underlying transport contortions
+	// and can work with a high level grpc connection. When the underlying connection goes away
+	// the context will be canceled.
+
+	glog.Infof("Handling uplink from %q", remoteAddr)
+	disconnect := func() { incomingConn.Close() }
+	if err := handleUplink(ctx, conn, remoteAddr, disconnect, cfg); err != nil {
+		glog.Errorf("%+v", err)
+	}
+	// the uplink connection is kept open after errors, which are relayed to the client.
+	<-ctx.Done()
+	return nil
+}
+
+// listenAdminGRPC serves the admin gRPC API.
+func listenAdminGRPC(addr string, srv *admin.Server) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		glog.Fatalf("could not listen: %v", err)
 	}
+	gs := grpc.NewServer()
+	adminpb.RegisterAdminServer(gs, srv)
+	glog.Fatalf("could not serve the admin gRPC API: %v", gs.Serve(lis))
 }
 
 func listenHTTP(haddr string) error {
@@ -229,40 +506,429 @@ func listenHTTP(haddr string) error {
 	return http.ListenAndServe(haddr, clientIPWrapper.Handler(promhttpmux.Instrument(mux)))
 }
 
-func run(uaddr, haddr, domain string, ports []int32, certPath, keyPath string) error {
+// acmeConfig configures the ACME client obtaining the ingress certificate.
+type acmeConfig struct {
+	directory string
+	email     string
+	cacheDir  string
+	caPath    string
+
+	hostnameCacheDir string
+}
+
+// httpClient returns the HTTP client used to talk to the ACME server.
+func (cfg acmeConfig) httpClient() (*http.Client, error) {
+	if cfg.caPath == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(cfg.caPath)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %q", cfg.caPath)
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}, nil
+}
+
+// newACMEManager creates an ACME certificate manager for the ingress domain, solving challenges with the given solver.
+func newACMEManager(cfg acmeConfig, domain string, solver certs.ChallengeSolver) (*certs.ACMEManager, error) {
+	hc, err := cfg.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	return certs.NewACMEManager(cfg.directory, cfg.email, []string{"*." + domain, domain}, cfg.cacheDir, solver, hc)
+}
+
+// newHostnameCertManager creates an ACME certificate manager for verified custom host names.
+// Certificates are obtained on demand through TLS-ALPN-01 challenges served by the ingress.
+func newHostnameCertManager(cfg acmeConfig, router *uplink.InProcessRouter) (*autocert.Manager, error) {
+	hc, err := cfg.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	return &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Cache:  autocert.DirCache(cfg.hostnameCacheDir),
+		Email:  cfg.email,
+		HostPolicy: func(ctx context.Context, host string) error {
+			if !router.HasHostname(host) {
+				return fmt.Errorf("%q is not a verified custom host name", host)
+			}
+			return nil
+		},
+		Client: &acme.Client{
+			DirectoryURL: cfg.directory,
+			HTTPClient:   hc,
+		},
+	}, nil
+}
+
+// ingressTLSConfig returns the TLS config of the ingress, serving the *.<domain> certificate from getCertificate
+// and per host name certificates for custom host names.
+func ingressTLSConfig(domain string, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error), hostCerts *autocert.Manager) *tls.Config {
+	isOwnDomain := func(name string) bool {
+		name = strings.ToLower(name)
+		return name == "" || name == domain || strings.HasSuffix(name, "."+domain)
+	}
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if isOwnDomain(hello.ServerName) {
+				return getCertificate(hello)
+			}
+			return hostCerts.GetCertificate(hello)
+		},
+		// answer TLS-ALPN-01 challenges for custom host names.
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			for _, p := range hello.SupportedProtos {
+				if p == acme.ALPNProto && !isOwnDomain(hello.ServerName) {
+					return &tls.Config{
+						GetCertificate: hostCerts.GetCertificate,
+						NextProtos:     []string{acme.ALPNProto},
+					}, nil
+				}
+			}
+			return nil, nil
+		},
+	}
+}
+
+// uplinkTLSConfig returns the TLS config of the uplink listener. It serves the certificate loaded from
+// certPath and keyPath if given, whose public key pin is logged, otherwise the certificate from getCertificate.
+func uplinkTLSConfig(certPath, keyPath string, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*tls.Config, error) {
+	if certPath != "" {
+		r, err := certs.NewFileReloader("uplink", certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		go r.Run(context.Background())
+		if cert, err := r.GetCertificate(nil); err == nil {
+			glog.Infof("uplink certificate public key pin: %s", uplink.PublicKeyPin(cert.Leaf))
+		}
+		getCertificate = r.GetCertificate
+	}
+	return &tls.Config{GetCertificate: getCertificate}, nil
+}
+
+// brokerConfig holds the broker settings, as parsed from the command line flags.
+type brokerConfig struct {
+	uplinkAddr    string
+	webSocketAddr string // if empty, WebSocket uplinks are disabled
+	httpAddr      string
+	dnsAddr       string // if empty, the built-in DNS server is disabled
+
+	domain       string
+	ports        portConfig
+	httpRedirect bool
+	publicIPs    []net.IP
+
+	// the ingress certificate is loaded from certPath and keyPath, unless it's obtained via ACME.
+	certPath string
+	keyPath  string
+	acme     acmeConfig
+
+	uplinkTLS      bool
+	uplinkCertPath string
+	uplinkKeyPath  string
+
+	placement   placement.Policy
+	balancer    uplink.Balancer
+	graceWindow time.Duration
+
+	adminToken    string // if empty, the admin API is disabled
+	adminGRPCAddr string
+
+	webhookURLs   []string
+	webhookEvents []uplink.EventType
+	webhookSecret []byte
+
+	authzPath string
+	tokenKeys []ed25519.PublicKey
+}
+
+func run(cfg brokerConfig) error {
 	grpc.EnableTracing = true
 	grpc_prometheus.EnableHandlingTimeHistogram()
 	trace.AuthRequest = func(*http.Request) (bool, bool) { return true, true }
 
-	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	mux := uplink.NewInProcessRouter(cfg.domain)
+	mux.Balancer = cfg.balancer
+	mux.GraceWindow = cfg.graceWindow
+
+	var ns *nameserver.Server
+	if cfg.dnsAddr != "" {
+		var err error
+		if ns, err = nameserver.NewServer(cfg.domain, cfg.publicIPs, mux); err != nil {
+			return err
+		}
+		go func() {
+			if err := ns.ListenAndServe(cfg.dnsAddr); err != nil {
+				glog.Fatalf("%+v", err)
+			}
+		}()
+	}
+
+	var getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	if cfg.acme.directory != "" {
+		m, err := newACMEManager(cfg.acme, cfg.domain, ns)
+		if err != nil {
+			return err
+		}
+		go m.Run(context.Background())
+		getCertificate = m.GetCertificate
+	} else {
+		r, err := certs.NewFileReloader("ingress", cfg.certPath, cfg.keyPath)
+		if err != nil {
+			return err
+		}
+		go r.Run(context.Background())
+		getCertificate = r.GetCertificate
+	}
+	hostCerts, err := newHostnameCertManager(cfg.acme, mux)
 	if err != nil {
 		return err
 	}
+	tlsConfig := ingressTLSConfig(cfg.domain, getCertificate, hostCerts)
+
+	var uplinkTLSCfg *tls.Config
+	if cfg.uplinkTLS {
+		if uplinkTLSCfg, err = uplinkTLSConfig(cfg.uplinkCertPath, cfg.uplinkKeyPath, getCertificate); err != nil {
+			return err
+		}
+	}
 
-	mux := uplink.NewInProcessRouter()
+	authorizer := authz.All{}
+	if cfg.authzPath != "" {
+		a, err := authz.NewFileAuthorizer(cfg.authzPath)
+		if err != nil {
+			return err
+		}
+		go a.Run(context.Background())
+		authorizer = append(authorizer, a)
+	}
+	if cfg.tokenKeys != nil {
+		authorizer = append(authorizer, authz.NewTokenAuthorizer(cfg.tokenKeys))
+	}
 
-	go listenUplink(uaddr, domain, ports, mux.Uplink())
-	for _, p := range ports {
-		go ingress.Listen(p, cert, mux.Ingress())
+	uplinkCfg := uplinkConfig{
+		domain:       cfg.domain,
+		enabledPorts: cfg.ports,
+		placement:    cfg.placement,
+		authorizer:   authorizer,
+		uplinks:      newUplinkCounter(),
+		changeUplink: mux.Uplink(),
+	}
+	go listenUplink(cfg.uplinkAddr, uplinkTLSCfg, uplinkCfg)
+	if cfg.webSocketAddr != "" {
+		go listenUplinkWebSocket(cfg.webSocketAddr, uplinkTLSCfg, uplinkCfg)
+	}
+	for _, p := range cfg.ports.tls {
+		go ingress.Listen(p, tlsConfig, cfg.ports.proxyTrusted(p), mux.Ingress())
+	}
+	for _, p := range cfg.ports.passthrough {
+		go ingress.ListenPassthrough(p, cfg.ports.proxyTrusted(p), mux.Ingress())
+	}
+	for _, p := range cfg.ports.http {
+		go ingress.ListenHTTP(p, cfg.httpRedirect, cfg.ports.proxyTrusted(p), mux.Ingress())
+	}
+
+	for _, u := range cfg.webhookURLs {
+		events, _ := mux.Subscribe(webhookBuffer, cfg.webhookEvents...)
+		go webhook.NewSender(u, cfg.webhookSecret).Run(events)
 	}
 
-	return listenHTTP(haddr)
+	if cfg.adminToken != "" {
+		adminSrv := admin.NewServer(mux, cfg.adminToken)
+		http.Handle(admin.PathPrefix, adminSrv)
+		if cfg.adminGRPCAddr != "" {
+			go listenAdminGRPC(cfg.adminGRPCAddr, adminSrv)
+		}
+	}
+
+	return listenHTTP(cfg.httpAddr)
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "mint-token" {
+		mintToken(os.Args[2:])
+		return
+	}
+
 	flag.Parse()
 	defer glog.Flush()
 
-	enabledPorts, err := ingress.ParsePorts(*ports)
+	var enabledPorts portConfig
+	if len(*ports) > 0 || len(*passthroughPorts) == 0 {
+		p, err := ingress.ParsePorts(*ports)
+		if err != nil {
+			glog.Exitf("%v", err)
+		}
+		enabledPorts.tls = p
+	}
+	p, err := ingress.ParsePortList(*passthroughPorts)
 	if err != nil {
 		glog.Exitf("%v", err)
 	}
+	enabledPorts.passthrough = p
+	if enabledPorts.http, err = ingress.ParsePortList(*httpPorts); err != nil {
+		glog.Exitf("%v", err)
+	}
+	seen := map[int32]bool{}
+	for _, ps := range [][]int32{enabledPorts.tls, enabledPorts.passthrough, enabledPorts.http} {
+		for _, p := range ps {
+			if seen[p] {
+				glog.Exitf("port %d is enabled for more than one ingress mode", p)
+			}
+			seen[p] = true
+		}
+	}
+
+	pp, err := ingress.ParsePortList(*proxyProtocolPorts)
+	if err != nil {
+		glog.Exitf("%v", err)
+	}
+	enabledPorts.proxyProtocol = map[int32]bool{}
+	for _, p := range pp {
+		enabledPorts.proxyProtocol[p] = true
+	}
+	if enabledPorts.proxyProtocolTrusted, err = proxyproto.ParseNetworks(*proxyProtocolTrusted); err != nil {
+		glog.Exitf("%v", err)
+	}
+	if len(pp) > 0 && len(enabledPorts.proxyProtocolTrusted) == 0 {
+		glog.Exitf("-proxy-protocol-port requires at least one -proxy-protocol-trusted network")
+	}
 
-	if *certPath == "" || *keyPath == "" {
+	if *acmeDirectory != "" {
+		if *daddr == "" {
+			glog.Exitf("-acme-directory requires the built-in DNS server (-dns) to solve DNS-01 challenges")
+		}
+	} else if *certPath == "" || *keyPath == "" {
 		glog.Exitf("-cert and -key are manadatory")
 	}
 
-	if err := run(*uaddr, *haddr, *domain, enabledPorts, *certPath, *keyPath); err != nil {
+	var ips []net.IP
+	for _, s := range *publicIPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			glog.Exitf("bad public IP address %q", s)
+		}
+		ips = append(ips, ip)
+	}
+	if *daddr != "" && len(ips) == 0 {
+		glog.Exitf("-dns requires at least one -public-ip")
+	}
+
+	acmeCfg := acmeConfig{
+		directory: *acmeDirectory,
+		email:     *acmeEmail,
+		cacheDir:  *acmeCache,
+		caPath:    *acmeCA,
+
+		hostnameCacheDir: *hostnameCertCache,
+	}
+
+	var placementPolicy placement.Policy
+	if len(*shards) > 0 {
+		// each shard's nameserver only knows its own tunnels, so resolvers would get NXDOMAIN
+		// for the tunnels of the other shards.
+		if *daddr != "" {
+			glog.Exitf("-dns can't be used with -shards; resolve the tunnel names with an external DNS")
+		}
+		r, err := placement.NewRendezvous(*shard, *shards)
+		if err != nil {
+			glog.Exitf("%v", err)
+		}
+		placementPolicy = r
+	}
+
+	if (*uplinkCertPath == "") != (*uplinkKeyPath == "") {
+		glog.Exitf("-uplink-cert and -uplink-key must be given together")
+	}
+
+	b, err := uplink.NewBalancer(*balancer)
+	if err != nil {
+		glog.Exitf("%v", err)
+	}
+
+	var adminToken string
+	if *adminTokenFile != "" {
+		t, err := os.ReadFile(*adminTokenFile)
+		if err != nil {
+			glog.Exitf("%v", err)
+		}
+		if adminToken = strings.TrimSpace(string(t)); adminToken == "" {
+			glog.Exitf("admin token file %q is empty", *adminTokenFile)
+		}
+	} else if *adminGRPCAddr != "" {
+		glog.Exitf("-admin-grpc requires -admin-token-file")
+	}
+
+	webhookEventTypes := defaultWebhookEvents
+	if len(*webhookEvents) > 0 {
+		webhookEventTypes = nil
+		for _, n := range *webhookEvents {
+			t, err := uplink.ParseEventType(n)
+			if err != nil {
+				glog.Exitf("%v", err)
+			}
+			webhookEventTypes = append(webhookEventTypes, t)
+		}
+	}
+
+	var webhookSecret []byte
+	if *webhookSecretFile != "" {
+		s, err := os.ReadFile(*webhookSecretFile)
+		if err != nil {
+			glog.Exitf("%v", err)
+		}
+		webhookSecret = bytes.TrimSpace(s)
+	}
+
+	var operatorKeys []ed25519.PublicKey
+	if *tokenKeys != "" {
+		if operatorKeys, err = authz.LoadPublicKeys(*tokenKeys); err != nil {
+			glog.Exitf("%v", err)
+		}
+	}
+
+	cfg := brokerConfig{
+		uplinkAddr:    *uaddr,
+		webSocketAddr: *uplinkWebSocketAddr,
+		httpAddr:      *haddr,
+		dnsAddr:       *daddr,
+
+		domain:       *domain,
+		ports:        enabledPorts,
+		httpRedirect: *httpRedirect,
+		publicIPs:    ips,
+
+		certPath: *certPath,
+		keyPath:  *keyPath,
+		acme:     acmeCfg,
+
+		uplinkTLS:      *uplinkTLS,
+		uplinkCertPath: *uplinkCertPath,
+		uplinkKeyPath:  *uplinkKeyPath,
+
+		placement:   placementPolicy,
+		balancer:    b,
+		graceWindow: *graceWindow,
+
+		adminToken:    adminToken,
+		adminGRPCAddr: *adminGRPCAddr,
+
+		webhookURLs:   *webhooks,
+		webhookEvents: webhookEventTypes,
+		webhookSecret: webhookSecret,
+
+		authzPath: *authzFile,
+		tokenKeys: operatorKeys,
+	}
+	if err := run(cfg); err != nil {
 		glog.Fatalf("%+v", err)
 	}
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mkmik/udig/pkg/authz"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUplinkPorts(t *testing.T) {
+	testCases := []struct {
+		name      string
+		requested []int32
+		enabled   []int32
+		grant     authz.Grant
+		want      []int32
+		code      codes.Code
+	}{
+		{name: "all enabled", enabled: []int32{443, 8443}, want: []int32{443, 8443}},
+		{name: "requested", requested: []int32{8443}, enabled: []int32{443, 8443}, want: []int32{8443}},
+		{name: "granted", enabled: []int32{443, 8443}, grant: authz.Grant{Ports: []int32{443}}, want: []int32{443}},
+		{name: "none enabled", code: codes.InvalidArgument},
+		{name: "requested not enabled", requested: []int32{9443}, enabled: []int32{443}, code: codes.InvalidArgument},
+		{name: "empty grant", enabled: []int32{443}, grant: authz.Grant{Ports: []int32{}}, code: codes.PermissionDenied},
+		{name: "requested not granted", requested: []int32{8443}, enabled: []int32{443, 8443}, grant: authz.Grant{Ports: []int32{443}}, code: codes.PermissionDenied},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := uplinkPorts("tid", tc.requested, tc.enabled, tc.grant)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("got code %v (%v), want %v", code, err, tc.code)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mkmik/stringlist"
+	"github.com/mkmik/udig/pkg/authz"
+	"github.com/mkmik/udig/pkg/ingress"
+	"golang.org/x/crypto/ed25519"
+)
+
+// operatorKey is the JSON encoding of the operator key pair signing invite tokens.
+type operatorKey struct {
+	Public  ed25519.PublicKey  `json:"public"`
+	Private ed25519.PrivateKey `json:"private"`
+}
+
+// mintToken implements the mint-token subcommand, which prints an invite token signed with the operator key.
+// The operator key is created if it doesn't exist; its public key goes in the -token-keys file of the brokers.
+func mintToken(args []string) {
+	flags := flag.NewFlagSet("mint-token", flag.ExitOnError)
+	keyPath := flags.String("key", "operator-key.json", "operator key pair file; created if missing")
+	ttl := flags.Duration("ttl", 24*time.Hour, "token validity")
+	maxUplinks := flags.Int("max-uplinks", 0, "maximum number of concurrent uplinks of the tunnel (0: no limit)")
+	tunnelID := flags.String("tunnel-id", "", "the only tunnel ID allowed to use the token (default: any)")
+	var ports stringlist.Value
+	flags.Var(&ports, "ports", "ingress ports the tunnel may use (default: all); comma separated or repeated flag")
+	flags.Parse(args)
+
+	portNums, err := ingress.ParsePortList(ports)
+	if err != nil {
+		glog.Exitf("%v", err)
+	}
+	key, err := loadOrCreateOperatorKey(*keyPath)
+	if err != nil {
+		glog.Exitf("%v", err)
+	}
+
+	token, err := authz.MintToken(key.Private, authz.Claims{
+		Expiry:     time.Now().Add(*ttl).Unix(),
+		Ports:      portNums,
+		MaxUplinks: *maxUplinks,
+		TunnelID:   *tunnelID,
+	})
+	if err != nil {
+		glog.Exitf("%v", err)
+	}
+	fmt.Println(token)
+}
+
+func loadOrCreateOperatorKey(path string) (*operatorKey, error) {
+	var key operatorKey
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if key.Public, key.Private, err = ed25519.GenerateKey(rand.Reader); err != nil {
+			return nil, err
+		}
+		if b, err = json.Marshal(&key); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "created operator key %q; add its public key to the -token-keys file of the brokers: %s\n", path, base64.StdEncoding.EncodeToString(key.Public))
+		return &key, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &key); err != nil {
+		return nil, fmt.Errorf("decoding operator key %q: %w", path, err)
+	}
+	return &key, nil
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	// registers debug handlers
@@ -25,6 +30,7 @@ import (
 	"github.com/hashicorp/yamux"
 	"github.com/mkmik/stringlist"
 	"github.com/mkmik/udig/pkg/egress"
+	"github.com/mkmik/udig/pkg/proxyproto"
 	"github.com/mkmik/udig/pkg/tunnel/tunnelpb"
 	"github.com/mkmik/udig/pkg/uplink"
 	"github.com/mkmik/udig/pkg/uplink/uplinkpb"
@@ -33,13 +39,36 @@ import (
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/net/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxRedirects is the maximum number of brokers tried by following redirects.
+	maxRedirects = 5
 )
 
 var (
 	laddr = flag.String("http", "", "listen address for http server (for debug, metrics)")
-	taddr = flag.String("addr", "uplink.udig.io:4000", "tunnel broker address")
-	maps  = stringlist.Flag("R", "remote_port:local_host:local_port; comma separated or repeated flag")
+	taddr = flag.String("addr", "uplink.udig.io:4000", "tunnel broker address(es): host:port, or a ws:// or wss:// URL to tunnel the uplink over WebSocket; comma separated")
+	maps  = stringlist.Flag("R", "[label:]remote_port:local_host:local_port[:proxy-v1|:proxy-v2]; comma separated or repeated flag")
+
+	useTLS    = flag.Bool("tls", true, "use TLS on the uplink connection to the tunnel broker")
+	brokerCA  = flag.String("broker-ca", "", "path to PEM encoded CA certificate(s) used to verify the tunnel broker (default: system roots)")
+	brokerPin = flag.String("broker-pin", "", "public key pin of the tunnel broker (sha256//<base64>); if set, it replaces the CA verification")
+	proxyURL  = flag.String("proxy", "", "proxy used to reach the tunnel broker: http://[user:password@]host:port (CONNECT), https://... or socks5://[user:password@]host:port (default: from HTTPS_PROXY, ALL_PROXY, NO_PROXY)")
+
+	uplinks = flag.Int("uplinks", 0, "number of concurrent uplink connections, spread over the -addr addresses (default: one per address)")
+
+	backoffInitial = flag.Duration("backoff-initial", 1*time.Second, "delay before the first reconnection attempt; it doubles (with jitter) after each consecutive failure")
+	backoffMax     = flag.Duration("backoff-max", 1*time.Minute, "maximum delay between reconnection attempts")
+	maxRetries     = flag.Int("max-retries", 0, "exit with an error after this many consecutive failed reconnection attempts (0: retry forever)")
+
+	passthrough = flag.Bool("passthrough", false, "request TLS passthrough; the local target must terminate TLS itself")
+	hostnames   = stringlist.Flag("hostname", "custom host name(s) to claim for the tunnel, verified via DNS; comma separated or repeated flag")
+	token       = flag.String("token", "", "invite token required by private tunnel brokers (default: $UDIG_TOKEN)")
+	balancer    = flag.String("balancer", "", "strategy the broker uses to spread streams among the uplinks of the tunnel: round-robin, least-active, two-choices, source-hash or random (default: the broker's)")
 
 	keyPairFile = flag.String("keypair", filepath.Join(defaultConfigDir, "keypair.json"), "Keypair file")
 
@@ -98,33 +127,155 @@ func serve(reg registerGRPC, conn net.Listener) error {
 	return gs.Serve(conn)
 }
 
-// keepDialing retries connecting when the connection fails
-func keepDialing(reg registerGRPC, taddr string) {
+// keepDialing retries connecting when the connection fails or is lost, waiting according to b.
+// It gives up after maxRetries consecutive failed retries, unless maxRetries is 0.
+// A connection that got the tunnel registered resets the failure count. A registration rejected by the broker
+// counts as a failed attempt, and a permanent rejection (see isPermanent) stops the uplink right away.
+//
+// When the broker redirects the uplink, the listed addresses are tried in order right away. Addresses already
+// tried since the last registration are skipped, and after maxRedirects hops it starts over from taddr.
+func keepDialing(reg registerGRPC, taddr string, d brokerDialer, b backoff, maxRetries int, t *connTracker, redirects <-chan []string, rejections <-chan error) error {
+	var (
+		failures int
+		pending  []string // redirect targets to try next, in order
+		visited  = map[string]bool{}
+	)
 	for {
-		if err := dial(reg, taddr); err != nil {
-			glog.Errorf("%+v", err)
-			time.Sleep(1 * time.Second)
+		addr := taddr
+		if len(pending) > 0 {
+			addr, pending = pending[0], pending[1:]
 		}
+		visited[addr] = true
+
+		t.set(stateConnecting, nil)
+		connectionAttempts.WithLabelValues(t.uplink).Inc()
+		registrations := t.registrationCount()
+
+		err := dial(reg, addr, d, redirects, rejections)
+		if t.registrationCount() != registrations {
+			failures = 0
+			visited = map[string]bool{}
+		}
+
+		var rerr *redirectError
+		if errors.As(err, &rerr) {
+			pending = nil
+			for _, a := range rerr.to {
+				if !visited[a] {
+					pending = append(pending, a)
+				}
+			}
+			if len(pending) > 0 && len(visited) <= maxRedirects {
+				glog.Infof("redirected from %q to %q", addr, pending)
+				continue
+			}
+			pending = nil
+			err = fmt.Errorf("redirect loop: %q redirected to %q, already tried %d brokers", addr, rerr.to, len(visited))
+		} else if err == nil {
+			err = fmt.Errorf("connection to %q closed", addr)
+		}
+		glog.Errorf("%+v", err)
+		connectionFailures.WithLabelValues(t.uplink).Inc()
+
+		var jerr *rejectedError
+		if isPermanent(err) {
+			t.set(stateRejected, err)
+			return err
+		} else if errors.As(err, &jerr) {
+			t.set(stateDegraded, err)
+		} else {
+			t.set(stateDisconnected, err)
+		}
+
+		if len(pending) > 0 {
+			// try the next redirect target right away.
+			continue
+		}
+		visited = map[string]bool{}
+
+		failures++
+		if maxRetries > 0 && failures > maxRetries {
+			return fmt.Errorf("giving up connecting to %q after %d retries", taddr, maxRetries)
+		}
+
+		delay := b.delay(failures)
+		glog.Infof("reconnecting in %s", delay)
+		time.Sleep(delay)
+	}
+}
+
+// redirectError reports that the broker redirected the uplink to other brokers.
+type redirectError struct {
+	to []string
+}
+
+func (e *redirectError) Error() string {
+	return fmt.Sprintf("redirected to %q", e.to)
+}
+
+// rejectedError reports that the broker rejected the tunnel registration.
+type rejectedError struct {
+	addr string
+	err  error
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("registration rejected by %q: %v", e.addr, e.err)
+}
+
+func (e *rejectedError) Unwrap() error {
+	return e.err
+}
+
+// isPermanent reports whether err is a registration rejection that retrying won't fix.
+func isPermanent(err error) bool {
+	var jerr *rejectedError
+	if !errors.As(err, &jerr) {
+		return false
 	}
+	switch status.Code(jerr.err) {
+	case codes.PermissionDenied, codes.InvalidArgument:
+		return true
+	}
+	return false
 }
 
 // dial connects to a tunnel broker and sets up a grpc service listening
-// in reverse through the client connection.
-func dial(reg registerGRPC, taddr string) error {
-	conn, err := net.DialTimeout("tcp", taddr, time.Second*5)
+// in reverse through the client connection. It returns a *redirectError if the
+// broker redirects the uplink, as reported on the redirects channel, and a *rejectedError
+// if the broker rejects the registration, as reported on the rejections channel.
+func dial(reg registerGRPC, taddr string, d brokerDialer, redirects <-chan []string, rejections <-chan error) error {
+	conn, err := d.dial(taddr)
 	if err != nil {
 		return fmt.Errorf("error dialing %q: %w", taddr, err)
 	}
 
 	grpcL, err := yamux.Server(conn, yamux.DefaultConfig())
 	if err != nil {
-		log.Fatalf("couldn't create yamux server: %s", err)
+		conn.Close()
+		return fmt.Errorf("couldn't create yamux server: %w", err)
 	}
 
-	if err := serve(reg, grpcL); err != nil {
-		return fmt.Errorf("serve after dialing %q: %w", taddr, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(reg, grpcL)
+	}()
+
+	select {
+	case to := <-redirects:
+		grpcL.Close()
+		<-served
+		return &redirectError{to: to}
+	case err := <-rejections:
+		grpcL.Close()
+		<-served
+		return &rejectedError{addr: taddr, err: err}
+	case err := <-served:
+		if err != nil {
+			return fmt.Errorf("serve after dialing %q: %w", taddr, err)
+		}
+		return nil
 	}
-	return nil
 }
 
 // listen spawns a http server for debug (pprof, tracing, local debug uplink protocol)
@@ -193,68 +344,263 @@ func ensureKeypair(keyPairFile string) (ed25519.PublicKey, ed25519.PrivateKey, e
 	return keypair.Public, keypair.Private, nil
 }
 
-func run(laddr, taddr, eaddr string, ingressPorts []int32, keyPairFile string) error {
+// brokerTLSConfig returns the TLS config used to connect to the tunnel broker. The broker certificate
+// is verified against the CA certificates in caPath (or the system roots), unless a public key pin is given.
+func brokerTLSConfig(caPath, pin string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if pin != "" {
+		if err := uplink.ValidatePin(pin); err != nil {
+			return nil, err
+		}
+		// the pin replaces the chain and host name verification, which would otherwise fail for
+		// self-signed broker certificates.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = uplink.VerifyPin(pin)
+	} else if caPath != "" {
+		b, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %q", caPath)
+		}
+	}
+	return cfg, nil
+}
+
+// linkConfig holds the client settings, as parsed from the command line flags.
+type linkConfig struct {
+	httpAddr    string
+	brokerAddrs []string
+	uplinks     int // if zero, one per broker address
+	dialer      brokerDialer
+	backoff     backoff
+	maxRetries  int
+
+	targets      map[egress.Route]egress.Target
+	ingressPorts []int32
+	passthrough  bool
+	hostnames    []string
+	balancer     string
+	token        string
+
+	keyPairFile string
+}
+
+func run(cfg linkConfig) error {
 	grpc.EnableTracing = true
 	grpc_prometheus.EnableHandlingTimeHistogram()
 	trace.AuthRequest = func(*http.Request) (bool, bool) { return true, true }
 
-	pub, priv, err := ensureKeypair(keyPairFile)
+	pub, priv, err := ensureKeypair(cfg.keyPairFile)
 	if err != nil {
 		return err
 	}
 
-	sup := make(chan uplink.StatusUpdate)
-	go func() {
-		for up := range sup {
-			for _, i := range up.Ingress {
-				if strings.HasSuffix(i, ":443") {
-					fmt.Printf("https://%s\n", strings.TrimSuffix(i, ":443"))
-				} else {
-					fmt.Printf("%s\n", i)
+	for _, h := range cfg.hostnames {
+		fmt.Fprintf(os.Stderr, "to claim %s, point it to the tunnel with a CNAME record and add this TXT record:\n  %s TXT %q\n", h, uplink.HostnameProofName(h), uplink.HostnameProof(priv, h))
+	}
+
+	eg, err := egress.NewServer(cfg.targets)
+	if err != nil {
+		return err
+	}
+
+	uplinks := cfg.uplinks
+	if uplinks == 0 {
+		uplinks = len(cfg.brokerAddrs)
+	}
+	var (
+		trackers connTrackers
+		debugReg registerGRPC
+		wg       sync.WaitGroup
+	)
+	printer := &ingressPrinter{targets: cfg.targets}
+	for i := 0; i < uplinks; i++ {
+		// uplinks are spread over the broker addresses.
+		taddr := cfg.brokerAddrs[i%len(cfg.brokerAddrs)]
+		t := newConnTracker(i, taddr)
+		trackers = append(trackers, t)
+
+		sup := make(chan uplink.StatusUpdate)
+		redirects := make(chan []string, 1)
+		rejections := make(chan error, 1)
+		go func() {
+			for up := range sup {
+				if up.Redirect != nil {
+					select {
+					case redirects <- up.Redirect:
+					default:
+					}
+					continue
+				}
+				if up.Err != nil {
+					select {
+					case rejections <- up.Err:
+					default:
+					}
+					continue
 				}
+				t.setRegistered(up.Ingress)
+				printer.print(up.Ingress)
 			}
+		}()
+
+		// each uplink has its own uplink server, so that registration outcomes are tracked per uplink.
+		up, err := uplink.NewServer(cfg.ingressPorts, pub, priv, sup)
+		if err != nil {
+			return err
+		}
+		up.Passthrough = cfg.passthrough
+		up.Hostnames = cfg.hostnames
+		up.Balancer = cfg.balancer
+		up.Token = cfg.token
+
+		reg := func(gs *grpc.Server) {
+			uplinkpb.RegisterUplinkServer(gs, up)
+			tunnelpb.RegisterTunnelServer(gs, eg)
+		}
+		if debugReg == nil {
+			debugReg = reg
 		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// an uplink that gives up stops on its own; the others keep serving the tunnel.
+			if err := keepDialing(reg, taddr, cfg.dialer, cfg.backoff, cfg.maxRetries, t, redirects, rejections); err != nil {
+				glog.Errorf("uplink %s stopped: %+v", t.uplink, err)
+			}
+		}()
+	}
+	http.Handle("/status", trackers)
+
+	go func() {
+		wg.Wait()
+		glog.Exitf("all uplinks gave up")
 	}()
 
-	up, err := uplink.NewServer(ingressPorts, pub, priv, sup)
-	if err != nil {
-		return err
+	return listen(debugReg, cfg.httpAddr)
+}
+
+// ingressPrinter prints the URLs of the tunnel ingress addresses when they change.
+// Multiple uplinks usually report the same addresses, which are printed only once.
+type ingressPrinter struct {
+	targets map[egress.Route]egress.Target
+
+	mu   sync.Mutex
+	last string
+}
+
+func (p *ingressPrinter) print(ingress []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := strings.Join(ingress, ",")
+	if key == p.last {
+		return
+	}
+	p.last = key
+	for _, i := range ingress {
+		for _, u := range ingressURLs(i, p.targets) {
+			fmt.Println(u)
+		}
 	}
+}
 
-	eg, err := egress.NewServer(eaddr)
+// ingressURLs returns the URLs under which an ingress host:port is reachable, one for each
+// host name label routed on that port.
+func ingressURLs(ingress string, targets map[egress.Route]egress.Target) []string {
+	host, port, err := net.SplitHostPort(ingress)
 	if err != nil {
-		return err
+		return []string{ingress}
 	}
-
-	reg := func(gs *grpc.Server) {
-		uplinkpb.RegisterUplinkServer(gs, up)
-		tunnelpb.RegisterTunnelServer(gs, eg)
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return []string{ingress}
 	}
 
-	go keepDialing(reg, taddr)
+	var labels []string
+	for r := range targets {
+		if r.Port == int32(p) && r.Label != "" {
+			labels = append(labels, r.Label)
+		}
+	}
+	sort.Strings(labels)
+	if _, found := targets[egress.Route{Port: int32(p)}]; found || len(labels) == 0 {
+		labels = append([]string{""}, labels...)
+	}
 
-	return listen(reg, laddr)
+	var res []string
+	for _, l := range labels {
+		h := host
+		if l != "" {
+			h = l + "." + host
+		}
+		switch port {
+		case "443":
+			res = append(res, "https://"+h)
+		case "80":
+			res = append(res, "http://"+h)
+		default:
+			res = append(res, net.JoinHostPort(h, port))
+		}
+	}
+	return res
 }
 
-// parses a slice of remote_port:local_host:local_port.
-// for now we support only one egress
-func parsePortMaps(portMaps []string) (ports []int32, egress string, err error) {
+// parses a slice of [label:]remote_port:local_host:local_port[:proxy-v1|:proxy-v2].
+// Each remote port, optionally qualified by a host name label, is mapped to its own egress target.
+func parsePortMaps(portMaps []string) (ports []int32, targets map[egress.Route]egress.Target, err error) {
+	targets = map[egress.Route]egress.Target{}
 	for _, s := range portMaps {
 		c := strings.SplitN(s, ":", 2)
+		if len(c) != 2 {
+			return nil, nil, fmt.Errorf("bad port mapping %q", s)
+		}
+
+		var label string
+		if _, err := strconv.Atoi(c[0]); err != nil {
+			label = strings.ToLower(c[0])
+			if c = strings.SplitN(c[1], ":", 2); len(c) != 2 {
+				return nil, nil, fmt.Errorf("bad port mapping %q", s)
+			}
+		}
 
 		i, err := strconv.Atoi(c[0])
 		if err != nil {
-			return nil, "", fmt.Errorf("parsing port %q: %w", s, err)
+			return nil, nil, fmt.Errorf("parsing port %q: %w", s, err)
 		}
 
-		if egress != "" && c[1] != egress {
-			return nil, "", fmt.Errorf("we currently support only one egress, found %q and %q", egress, c[1])
+		t := egress.Target{Addr: c[1]}
+		if j := strings.LastIndex(t.Addr, ":"); j != -1 && strings.HasPrefix(t.Addr[j+1:], "proxy-") {
+			t.ProxyProtocol, err = proxyproto.ParseVersion(strings.TrimPrefix(t.Addr[j+1:], "proxy-"))
+			if err != nil {
+				return nil, nil, fmt.Errorf("parsing port mapping %q: %w", s, err)
+			}
+			t.Addr = t.Addr[:j]
 		}
-		egress = c[1]
 
-		ports = append(ports, int32(i))
+		r := egress.Route{Label: label, Port: int32(i)}
+		if _, found := targets[r]; found {
+			return nil, nil, fmt.Errorf("duplicate mapping for %q", strings.TrimPrefix(label+":"+c[0], ":"))
+		}
+		if !hasPort(targets, r.Port) {
+			ports = append(ports, r.Port)
+		}
+		targets[r] = t
+	}
+	return ports, targets, err
+}
+
+func hasPort(targets map[egress.Route]egress.Target, port int32) bool {
+	for r := range targets {
+		if r.Port == port {
+			return true
+		}
 	}
-	return ports, egress, err
+	return false
 }
 
 func main() {
@@ -269,12 +615,63 @@ func main() {
 		glog.Exitf("requiring least one -R")
 	}
 
-	ingressPortNums, eaddr, err := parsePortMaps(*maps)
+	ingressPortNums, targets, err := parsePortMaps(*maps)
 	if err != nil {
 		glog.Exitf("%v", err)
 	}
 
-	if err := run(*laddr, *taddr, eaddr, ingressPortNums, *keyPairFile); err != nil {
+	var d brokerDialer
+	if *useTLS {
+		if d.tlsConfig, err = brokerTLSConfig(*brokerCA, *brokerPin); err != nil {
+			glog.Exitf("%v", err)
+		}
+	} else if *brokerCA != "" || *brokerPin != "" {
+		glog.Exitf("-broker-ca and -broker-pin require -tls")
+	}
+
+	if *proxyURL != "" {
+		if d.proxy, err = url.Parse(*proxyURL); err != nil {
+			glog.Exitf("bad -proxy: %v", err)
+		}
+		switch d.proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			glog.Exitf("unsupported -proxy scheme %q", d.proxy.Scheme)
+		}
+	}
+
+	if *backoffInitial <= 0 || *backoffMax < *backoffInitial {
+		glog.Exitf("-backoff-initial must be positive and not greater than -backoff-max")
+	}
+	b := backoff{initial: *backoffInitial, max: *backoffMax}
+
+	if *uplinks < 0 {
+		glog.Exitf("-uplinks must not be negative")
+	}
+
+	tok := *token
+	if tok == "" {
+		tok = os.Getenv("UDIG_TOKEN")
+	}
+
+	cfg := linkConfig{
+		httpAddr:    *laddr,
+		brokerAddrs: strings.Split(*taddr, ","),
+		uplinks:     *uplinks,
+		dialer:      d,
+		backoff:     b,
+		maxRetries:  *maxRetries,
+
+		targets:      targets,
+		ingressPorts: ingressPortNums,
+		passthrough:  *passthrough,
+		hostnames:    *hostnames,
+		balancer:     *balancer,
+		token:        tok,
+
+		keyPairFile: *keyPairFile,
+	}
+	if err := run(cfg); err != nil {
 		glog.Fatalf("%+v", err)
 	}
 }
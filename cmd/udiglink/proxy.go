@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+func init() {
+	proxy.RegisterDialerType("http", newConnectDialer)
+	proxy.RegisterDialerType("https", newConnectDialer)
+}
+
+// proxyFromEnvironment returns the proxy for a connection to the broker at addr according to the
+// HTTPS_PROXY, HTTP_PROXY (for plaintext WebSocket uplinks), ALL_PROXY and NO_PROXY environment
+// variables (or their lowercase versions), or nil if the broker is to be reached directly.
+func proxyFromEnvironment(addr string, secure bool) (*url.URL, error) {
+	target := &url.URL{Scheme: "http", Host: addr}
+	if secure {
+		target.Scheme = "https"
+	}
+
+	cfg := httpproxy.FromEnvironment()
+	u, err := cfg.ProxyFunc()(target)
+	if u != nil || err != nil {
+		return u, err
+	}
+
+	all := getenv("ALL_PROXY", "all_proxy")
+	if all == "" {
+		return nil, nil
+	}
+	return (&httpproxy.Config{HTTPProxy: all, HTTPSProxy: all, NoProxy: cfg.NoProxy}).ProxyFunc()(target)
+}
+
+func getenv(names ...string) string {
+	for _, n := range names {
+		if v := os.Getenv(n); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// dialContext connects to addr through a proxy (http, https, socks5 or socks5h URL), or directly if p is nil.
+func dialContext(ctx context.Context, p *url.URL, addr string) (net.Conn, error) {
+	direct := &net.Dialer{}
+	if p == nil {
+		return direct.DialContext(ctx, "tcp", addr)
+	}
+
+	d, err := proxy.FromURL(p, direct)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %q: %w", p.Redacted(), err)
+	}
+	conn, err := d.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("via proxy %q: %w", p.Redacted(), err)
+	}
+	return conn, nil
+}
+
+// connectDialer dials through an HTTP(S) proxy using the CONNECT method, with basic authentication
+// if the proxy URL carries user information.
+type connectDialer struct {
+	proxy   *url.URL
+	forward proxy.Dialer
+}
+
+func newConnectDialer(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	return &connectDialer{proxy: u, forward: forward}, nil
+}
+
+// Dial implements proxy.Dialer.
+func (d *connectDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext implements proxy.ContextDialer.
+func (d *connectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	hostport := d.proxy.Host
+	if d.proxy.Port() == "" {
+		port := "80"
+		if d.proxy.Scheme == "https" {
+			port = "443"
+		}
+		hostport = net.JoinHostPort(d.proxy.Hostname(), port)
+	}
+
+	conn, err := d.forward.(proxy.ContextDialer).DialContext(ctx, network, hostport)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if d.proxy.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: d.proxy.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u := d.proxy.User; u != nil {
+		pass, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pass)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose reads go through a buffered reader that may hold data
+// already read from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	connectionState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "udiglink_connection_state",
+		Help: "State of the uplink connections to the tunnel broker; 1 for the current state, 0 otherwise.",
+	}, []string{"uplink", "state"})
+	connectionAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "udiglink_connection_attempts_total",
+		Help: "Number of attempts to connect to the tunnel broker, by uplink.",
+	}, []string{"uplink"})
+	connectionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "udiglink_connection_failures_total",
+		Help: "Number of failed or lost connections to the tunnel broker, by uplink.",
+	}, []string{"uplink"})
+)
+
+func init() {
+	prometheus.MustRegister(connectionState, connectionAttempts, connectionFailures)
+}
+
+// connState is the state of the uplink connection to the tunnel broker.
+type connState int
+
+const (
+	// stateConnecting means that the connection is being established and the tunnel is not registered yet.
+	stateConnecting connState = iota
+	// stateRegistered means that the broker accepted the tunnel and reported its ingress addresses.
+	stateRegistered
+	// stateDegraded means that the broker rejected the tunnel registration, and a retry is pending.
+	stateDegraded
+	// stateDisconnected means that the connection failed or was lost, and a retry is pending.
+	stateDisconnected
+	// stateRejected means that the broker rejected the tunnel registration for good, and the uplink stopped.
+	stateRejected
+)
+
+var connStateNames = []string{"connecting", "registered", "degraded", "disconnected", "rejected"}
+
+// connStateRank orders states from the worst to the best.
+var connStateRank = map[connState]int{
+	stateRejected:     0,
+	stateDisconnected: 1,
+	stateConnecting:   2,
+	stateDegraded:     3,
+	stateRegistered:   4,
+}
+
+func (s connState) String() string {
+	return connStateNames[s]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s connState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// connTracker tracks the state of an uplink connection and exports it as metrics.
+type connTracker struct {
+	uplink string // metric label
+	addr   string
+
+	mu            sync.Mutex
+	state         connState
+	since         time.Time
+	lastError     string
+	ingress       []string
+	registrations int
+}
+
+// newConnTracker creates a connTracker for the i-th uplink, connected to the broker at addr.
+func newConnTracker(i int, addr string) *connTracker {
+	t := &connTracker{uplink: strconv.Itoa(i), addr: addr}
+	t.set(stateConnecting, nil)
+	return t
+}
+
+// set moves to a new state; err is the cause of a degraded or disconnected state.
+func (t *connTracker) set(s connState, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s != t.state || t.since.IsZero() {
+		t.since = time.Now()
+	}
+	t.state = s
+	if err != nil {
+		t.lastError = err.Error()
+	}
+	if s != stateRegistered {
+		t.ingress = nil
+	}
+
+	for i, n := range connStateNames {
+		v := 0.0
+		if connState(i) == s {
+			v = 1
+		}
+		connectionState.WithLabelValues(t.uplink, n).Set(v)
+	}
+}
+
+// setRegistered moves to the registered state.
+func (t *connTracker) setRegistered(ingress []string) {
+	t.set(stateRegistered, nil)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ingress = ingress
+	t.registrations++
+}
+
+// registrationCount returns how many times the tunnel got registered so far.
+func (t *connTracker) registrationCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.registrations
+}
+
+// connStatus is the JSON representation of the state of an uplink connection.
+type connStatus struct {
+	Addr      string    `json:"addr"`
+	State     connState `json:"state"`
+	Since     time.Time `json:"since"`
+	LastError string    `json:"lastError,omitempty"`
+	Ingress   []string  `json:"ingress,omitempty"`
+}
+
+func (t *connTracker) status() connStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return connStatus{t.addr, t.state, t.since, t.lastError, t.ingress}
+}
+
+// connTrackers tracks all the uplink connections and serves their state as JSON on the debug HTTP server.
+type connTrackers []*connTracker
+
+// ServeHTTP implements http.Handler.
+//
+// The overall state is the best state among the uplinks, since a single registered uplink is enough to serve the tunnel.
+func (ts connTrackers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st := struct {
+		State   connState    `json:"state"`
+		Uplinks []connStatus `json:"uplinks"`
+	}{State: stateRejected}
+	for _, t := range ts {
+		s := t.status()
+		if connStateRank[s.State] > connStateRank[st.State] {
+			st.State = s.State
+		}
+		st.Uplinks = append(st.Uplinks, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// backoff computes exponentially growing retry delays with jitter.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+// delay returns the delay before retrying after n consecutive failures (n >= 1): initial*2^(n-1), capped at max,
+// reduced by a random jitter of up to half of it so that clients don't retry in lockstep.
+func (b backoff) delay(n int) time.Duration {
+	d := b.initial
+	for i := 1; i < n && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	return d - time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mkmik/udig/pkg/uplink"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// dialTimeout bounds the time spent connecting to the tunnel broker, handshakes included.
+	dialTimeout = 5 * time.Second
+)
+
+// brokerDialer opens uplink connections to a tunnel broker.
+type brokerDialer struct {
+	// tlsConfig, if not nil, wraps host:port connections in TLS and verifies wss:// connections.
+	tlsConfig *tls.Config
+	// proxy, if not nil, is the proxy used to reach the broker; otherwise it's taken from the environment.
+	proxy *url.URL
+}
+
+// dial opens the uplink connection to a tunnel broker. The taddr address is either host:port,
+// for a TCP connection wrapped in TLS if configured, or a ws:// or wss:// URL, for a WebSocket
+// connection that can traverse HTTP(S)-only networks.
+func (d brokerDialer) dial(taddr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	if strings.HasPrefix(taddr, "ws://") || strings.HasPrefix(taddr, "wss://") {
+		return d.dialWebSocket(ctx, taddr)
+	}
+
+	conn, err := d.dialTCP(ctx, taddr, d.tlsConfig != nil)
+	if err != nil || d.tlsConfig == nil {
+		return conn, err
+	}
+	return handshakeTLS(ctx, conn, d.tlsConfig, taddr)
+}
+
+// dialTCP connects to addr, through a proxy if one is configured. The secure flag tells whether the connection will
+// carry TLS, which selects the proxy environment variable.
+func (d brokerDialer) dialTCP(ctx context.Context, addr string, secure bool) (net.Conn, error) {
+	p := d.proxy
+	if p == nil {
+		var err error
+		if p, err = proxyFromEnvironment(addr, secure); err != nil {
+			return nil, err
+		}
+	}
+	return dialContext(ctx, p, addr)
+}
+
+// dialWebSocket opens a WebSocket connection to a tunnel broker. The wss scheme always uses TLS,
+// verified according to tlsConfig if not nil. The path defaults to uplink.WebSocketPath.
+func (d brokerDialer) dialWebSocket(ctx context.Context, rawURL string) (net.Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		u.Path = uplink.WebSocketPath
+	}
+	secure := u.Scheme == "wss"
+
+	hostport := u.Host
+	if u.Port() == "" {
+		if secure {
+			hostport = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			hostport = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	conn, err := d.dialTCP(ctx, hostport, secure)
+	if err != nil {
+		return nil, err
+	}
+
+	if secure {
+		cfg := d.tlsConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if conn, err = handshakeTLS(ctx, conn, cfg, hostport); err != nil {
+			return nil, err
+		}
+	}
+
+	origin := "http://" + u.Host
+	if secure {
+		origin = "https://" + u.Host
+	}
+	wsCfg, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	ws, err := websocket.NewClient(wsCfg, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake with %q: %w", u, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+// handshakeTLS wraps conn in a TLS client connection to addr. The server name defaults to the host part of addr.
+func handshakeTLS(ctx context.Context, conn net.Conn, cfg *tls.Config, addr string) (net.Conn, error) {
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			cfg.ServerName = host
+		} else {
+			cfg.ServerName = addr
+		}
+	}
+	tc := tls.Client(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
@@ -0,0 +1,190 @@
+// Package admin implements the administration API of the tunnel broker, served over HTTP and gRPC.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/mkmik/udig/pkg/admin/adminpb"
+	"github.com/mkmik/udig/pkg/uplink"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// PathPrefix is the path under which the HTTP API is served.
+	PathPrefix = "/admin/"
+
+	// watchBuffer is the number of events buffered for each Watch call.
+	watchBuffer = 256
+)
+
+// A Router holds the tunnels managed by the API; uplink.InProcessRouter implements it.
+type Router interface {
+	Tunnels() []uplink.TunnelInfo
+	Disconnect(tunnelID, uplinkID string) (int, error)
+	Subscribe(size int, types ...uplink.EventType) (<-chan uplink.Event, func())
+}
+
+// Server serves the administration API. Every request must carry the admin token
+// in an "Authorization: Bearer <token>" header (or gRPC metadata entry).
+//
+// The HTTP API has two endpoints:
+//
+//	GET  /admin/tunnels                                lists the tunnels as JSON
+//	POST /admin/disconnect?tunnel=<id>[&uplink=<id>]  disconnects an uplink, or all the uplinks of a tunnel
+type Server struct {
+	adminpb.UnimplementedAdminServer
+	router Router
+	token  string
+}
+
+// NewServer creates an admin API server for the tunnels of router, protected by token.
+func NewServer(router Router, token string) *Server {
+	return &Server{router: router, token: token}
+}
+
+// authorized returns true if an authorization header value carries the admin token.
+func (s *Server) authorized(auth string) bool {
+	const prefix = "Bearer "
+	if s.token == "" || !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, prefix)), []byte(s.token)) == 1
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r.Header.Get("Authorization")) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, PathPrefix) {
+	case "tunnels":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		tunnels := s.router.Tunnels()
+		if tunnels == nil {
+			tunnels = []uplink.TunnelInfo{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tunnels)
+
+	case "disconnect":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		tid := r.FormValue("tunnel")
+		if tid == "" {
+			http.Error(w, "missing tunnel parameter", http.StatusBadRequest)
+			return
+		}
+		n, err := s.router.Disconnect(tid, r.FormValue("uplink"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		glog.Infof("admin: disconnected %d uplinks of tunnel %q", n, tid)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Disconnected int `json:"disconnected"`
+		}{n})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorize checks the admin token carried by the gRPC request metadata.
+func (s *Server) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if s.authorized(auth) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or bad admin token")
+}
+
+// ListTunnels implements the admin gRPC service.
+func (s *Server) ListTunnels(ctx context.Context, req *adminpb.ListTunnelsRequest) (*adminpb.ListTunnelsResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	res := &adminpb.ListTunnelsResponse{}
+	for _, t := range s.router.Tunnels() {
+		pt := &adminpb.TunnelInfo{TunnelId: t.TunnelID}
+		for _, u := range t.Uplinks {
+			pt.Uplinks = append(pt.Uplinks, &adminpb.UplinkInfo{
+				UplinkId:       u.UplinkID,
+				RemoteAddr:     u.RemoteAddr,
+				RegisteredUnix: u.Registered.Unix(),
+				Passthrough:    u.Passthrough,
+				Hostnames:      u.Hostnames,
+				ActiveStreams:  u.ActiveStreams,
+				BytesUp:        u.BytesUp,
+				BytesDown:      u.BytesDown,
+			})
+		}
+		res.Tunnels = append(res.Tunnels, pt)
+	}
+	return res, nil
+}
+
+// Disconnect implements the admin gRPC service.
+func (s *Server) Disconnect(ctx context.Context, req *adminpb.DisconnectRequest) (*adminpb.DisconnectResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if req.TunnelId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing tunnel ID")
+	}
+
+	n, err := s.router.Disconnect(req.TunnelId, req.UplinkId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	glog.Infof("admin: disconnected %d uplinks of tunnel %q", n, req.TunnelId)
+	return &adminpb.DisconnectResponse{Disconnected: int32(n)}, nil
+}
+
+// Watch implements the admin gRPC service.
+func (s *Server) Watch(req *adminpb.WatchRequest, stream adminpb.Admin_WatchServer) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+
+	events, cancel := s.router.Subscribe(watchBuffer)
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e := <-events:
+			if req.TunnelId != "" && e.TunnelID != req.TunnelId {
+				continue
+			}
+			if err := stream.Send(&adminpb.Event{
+				Type:         string(e.Type),
+				TimeUnixNano: e.Time.UnixNano(),
+				TunnelId:     e.TunnelID,
+				UplinkId:     e.UplinkID,
+				RemoteAddr:   e.RemoteAddr,
+				Ingress:      e.Ingress,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
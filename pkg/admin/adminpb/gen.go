@@ -0,0 +1,3 @@
+//go:generate protoc -I. -I../../.. --go_out=../../.. --go_opt=paths=source_relative --go-grpc_out=../../.. --go-grpc_opt=paths=source_relative pkg/admin/adminpb/admin.proto
+
+package adminpb
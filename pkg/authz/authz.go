@@ -0,0 +1,75 @@
+// Package authz decides which tunnel clients may register with the tunnel broker.
+package authz
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// A Request describes a tunnel registration whose signature has been verified.
+type Request struct {
+	PublicKey ed25519.PublicKey
+	TunnelID  string
+	// Token is the invite token sent by the client, if any (see TokenAuthorizer).
+	Token string
+}
+
+// A Grant describes what an authorized tunnel may do.
+type Grant struct {
+	// Ports lists the ingress ports the tunnel may use; nil means all the ports enabled on the broker,
+	// and an empty list none.
+	Ports []int32
+	// MaxUplinks bounds the concurrent uplinks of the tunnel; zero means no limit.
+	MaxUplinks int
+}
+
+// An Authorizer decides whether a tunnel may be registered.
+type Authorizer interface {
+	// Authorize returns an error if the registration is denied.
+	Authorize(req Request) (Grant, error)
+}
+
+// All is an Authorizer that accepts a tunnel only if all of its authorizers do, with the most restrictive grant.
+type All []Authorizer
+
+// Authorize implements Authorizer.
+func (as All) Authorize(req Request) (Grant, error) {
+	var res Grant
+	for _, a := range as {
+		g, err := a.Authorize(req)
+		if err != nil {
+			return Grant{}, err
+		}
+		if g.Ports != nil {
+			res.Ports = intersect(res.Ports, g.Ports)
+		}
+		// an empty (not nil) port list means that the grants have no port in common.
+		if res.Ports != nil && len(res.Ports) == 0 {
+			return Grant{}, fmt.Errorf("tunnel %s is not allowed on any port", req.TunnelID)
+		}
+		if g.MaxUplinks > 0 && (res.MaxUplinks == 0 || g.MaxUplinks < res.MaxUplinks) {
+			res.MaxUplinks = g.MaxUplinks
+		}
+	}
+	return res, nil
+}
+
+// intersect returns the ports in both a and b, where a nil a means all ports.
+// The result is empty but not nil if a and b are disjoint.
+func intersect(a, b []int32) []int32 {
+	if a == nil {
+		return b
+	}
+	in := map[int32]bool{}
+	for _, p := range b {
+		in[p] = true
+	}
+	res := []int32{}
+	for _, p := range a {
+		if in[p] {
+			res = append(res, p)
+		}
+	}
+	return res
+}
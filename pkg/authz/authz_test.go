@@ -0,0 +1,90 @@
+package authz
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// fixed is an Authorizer returning a fixed outcome.
+type fixed struct {
+	grant Grant
+	err   error
+}
+
+func (f fixed) Authorize(Request) (Grant, error) { return f.grant, f.err }
+
+func TestIntersect(t *testing.T) {
+	testCases := []struct {
+		a, b []int32
+		want []int32
+	}{
+		{nil, nil, nil},
+		{nil, []int32{443}, []int32{443}},
+		{[]int32{443, 8443}, []int32{8443, 80}, []int32{8443}},
+		{[]int32{443}, []int32{80}, []int32{}},
+		{[]int32{443}, nil, []int32{}},
+	}
+	for _, tc := range testCases {
+		got := intersect(tc.a, tc.b)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("intersect(%v, %v) = %#v, want %#v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	req := Request{TunnelID: "tunnel"}
+	testCases := []struct {
+		name    string
+		as      All
+		want    Grant
+		wantErr bool
+	}{
+		{name: "empty", as: All{}, want: Grant{}},
+		{
+			name: "unrestricted",
+			as:   All{fixed{}, fixed{}},
+			want: Grant{},
+		},
+		{
+			name: "ports and uplinks",
+			as: All{
+				fixed{grant: Grant{Ports: []int32{443, 8443}, MaxUplinks: 4}},
+				fixed{grant: Grant{MaxUplinks: 2}},
+				fixed{grant: Grant{Ports: []int32{8443, 80}}},
+			},
+			want: Grant{Ports: []int32{8443}, MaxUplinks: 2},
+		},
+		{
+			name: "disjoint ports",
+			as: All{
+				fixed{grant: Grant{Ports: []int32{443}}},
+				fixed{grant: Grant{Ports: []int32{80}}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "denied",
+			as:      All{fixed{}, fixed{err: fmt.Errorf("denied")}},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.as.Authorize(req)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got grant %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package authz
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/mkmik/udig/pkg/filewatch"
+)
+
+// FileAuthorizer enforces a policy loaded from a file, and reloads it when the file changes
+// or when the process receives SIGHUP.
+//
+// Each line of the file is a rule, either "allow <key> [<port>,...]" or "deny <key>", where the key
+// is a tunnel ID or a base64 encoded Ed25519 public key. Empty lines and lines starting with # are ignored.
+// Denied keys are always rejected. If the file has allow rules, only the listed keys are accepted, limited
+// to the listed ports if any.
+type FileAuthorizer struct {
+	path string
+
+	// Watcher reloads the policy; its PollInterval can be changed before calling Run.
+	*filewatch.Watcher
+
+	mu     sync.RWMutex // protects policy
+	policy *policy
+}
+
+// policy is the parsed content of a policy file.
+type policy struct {
+	allow map[string][]int32 // key -> allowed ports; nil means all ports
+	deny  map[string]bool
+}
+
+// NewFileAuthorizer loads a policy file.
+func NewFileAuthorizer(path string) (*FileAuthorizer, error) {
+	a := &FileAuthorizer{path: path}
+	w, err := filewatch.New(a.reload, path)
+	if err != nil {
+		return nil, err
+	}
+	a.Watcher = w
+	return a, nil
+}
+
+// Authorize implements Authorizer.
+func (a *FileAuthorizer) Authorize(req Request) (Grant, error) {
+	a.mu.RLock()
+	p := a.policy
+	a.mu.RUnlock()
+
+	keys := []string{req.TunnelID, base64.StdEncoding.EncodeToString(req.PublicKey)}
+	for _, k := range keys {
+		if p.deny[k] {
+			return Grant{}, fmt.Errorf("tunnel %s is denied", req.TunnelID)
+		}
+	}
+	if len(p.allow) == 0 {
+		return Grant{}, nil
+	}
+	for _, k := range keys {
+		if ports, ok := p.allow[k]; ok {
+			return Grant{Ports: ports}, nil
+		}
+	}
+	return Grant{}, fmt.Errorf("tunnel %s is not allowed", req.TunnelID)
+}
+
+// reload parses the policy file and swaps it in. The current policy is kept on error.
+func (a *FileAuthorizer) reload() error {
+	p, err := parsePolicy(a.path)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.policy = p
+	a.mu.Unlock()
+
+	glog.Infof("loaded authorization policy %q: %d allow and %d deny rules", a.path, len(p.allow), len(p.deny))
+	return nil
+}
+
+func parsePolicy(path string) (*policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &policy{allow: map[string][]int32{}, deny: map[string]bool{}}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch {
+		case fields[0] == "allow" && (len(fields) == 2 || len(fields) == 3):
+			var ports []int32
+			if len(fields) == 3 {
+				for _, s := range strings.Split(fields[2], ",") {
+					port, err := strconv.ParseUint(s, 10, 16)
+					if err != nil {
+						return nil, fmt.Errorf("%s:%d: bad port %q", path, n, s)
+					}
+					ports = append(ports, int32(port))
+				}
+			}
+			p.allow[fields[1]] = ports
+		case fields[0] == "deny" && len(fields) == 2:
+			p.deny[fields[1]] = true
+		default:
+			return nil, fmt.Errorf("%s:%d: expecting \"allow <key> [<port>,...]\" or \"deny <key>\"", path, n)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
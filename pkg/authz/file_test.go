@@ -0,0 +1,127 @@
+package authz
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var (
+	key1 = []byte("01234567890123456789012345678901")
+	key2 = []byte("abcdefghijklmnopqrstuvwxyzabcdef")
+
+	key1Base64 = base64.StdEncoding.EncodeToString(key1)
+)
+
+// writePolicy writes a policy file in dir and returns its path.
+func writePolicy(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "policy")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileAuthorizer(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy string
+		req    Request
+		want   Grant
+		denied bool
+	}{
+		{name: "empty policy", policy: "# nothing\n\n", req: Request{TunnelID: "t1", PublicKey: key1}},
+		{name: "allowed by tunnel ID", policy: "allow t1\n", req: Request{TunnelID: "t1", PublicKey: key1}},
+		{name: "allowed by key", policy: "allow " + key1Base64 + "\n", req: Request{TunnelID: "t1", PublicKey: key1}},
+		{name: "not allowed", policy: "allow t1\n", req: Request{TunnelID: "t2", PublicKey: key2}, denied: true},
+		{name: "denied by tunnel ID", policy: "deny t1\n", req: Request{TunnelID: "t1", PublicKey: key1}, denied: true},
+		{name: "denied by key", policy: "deny " + key1Base64 + "\n", req: Request{TunnelID: "t1", PublicKey: key1}, denied: true},
+		{name: "not denied", policy: "deny t1\n", req: Request{TunnelID: "t2", PublicKey: key2}},
+		{name: "deny wins", policy: "allow t1\ndeny " + key1Base64 + "\n", req: Request{TunnelID: "t1", PublicKey: key1}, denied: true},
+		{
+			name:   "ports by tunnel ID",
+			policy: "allow t1 443,8443\nallow t2\n",
+			req:    Request{TunnelID: "t1", PublicKey: key1},
+			want:   Grant{Ports: []int32{443, 8443}},
+		},
+		{
+			name:   "ports by key",
+			policy: "allow t1 443\nallow  " + key1Base64 + "\t80\n",
+			req:    Request{TunnelID: "t3", PublicKey: key1},
+			want:   Grant{Ports: []int32{80}},
+		},
+		{name: "all ports", policy: "allow t1 443\nallow t2\n", req: Request{TunnelID: "t2", PublicKey: key2}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := NewFileAuthorizer(writePolicy(t, t.TempDir(), tc.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := a.Authorize(tc.req)
+			if denied := err != nil; denied != tc.denied {
+				t.Fatalf("got error %v, want denied %v", err, tc.denied)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFileAuthorizerMalformed(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy string
+	}{
+		{"missing key", "allow\n"},
+		{"unknown action", "permit t1\n"},
+		{"deny with ports", "deny t1 443\n"},
+		{"too many fields", "allow t1 443 8443\n"},
+		{"bad port", "allow t1 443,https\n"},
+		{"port out of range", "allow t1 65536\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewFileAuthorizer(writePolicy(t, t.TempDir(), "allow t2\n"+tc.policy)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	if _, err := NewFileAuthorizer(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestFileAuthorizerReload(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewFileAuthorizer(writePolicy(t, dir, "allow t1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := func(tunnelID string) bool {
+		_, err := a.Authorize(Request{TunnelID: tunnelID})
+		return err == nil
+	}
+
+	// a broken file keeps the current policy.
+	writePolicy(t, dir, "allow t2\nallow\n")
+	if err := a.reload(); err == nil {
+		t.Fatal("expected an error reloading a malformed file")
+	}
+	if !allowed("t1") || allowed("t2") {
+		t.Error("policy changed after a failed reload")
+	}
+
+	writePolicy(t, dir, "allow t2\n")
+	if err := a.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if allowed("t1") || !allowed("t2") {
+		t.Error("policy not replaced after a reload")
+	}
+}
@@ -0,0 +1,129 @@
+package authz
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Claims are the rights granted by an invite token.
+type Claims struct {
+	// Expiry is when the token expires, in seconds since the Unix epoch. Tunnels already registered are not affected.
+	Expiry int64 `json:"exp"`
+	// Ports lists the ingress ports the tunnel may use; empty means all the ports enabled on the broker.
+	Ports []int32 `json:"ports,omitempty"`
+	// MaxUplinks bounds the concurrent uplinks of the tunnel; zero means no limit.
+	MaxUplinks int `json:"maxUplinks,omitempty"`
+	// TunnelID, if not empty, is the only tunnel that can use the token.
+	TunnelID string `json:"tunnelId,omitempty"`
+}
+
+// MintToken creates an invite token carrying claims signed by an operator key.
+//
+// The token is the base64url encoded JSON claims, followed by a dot and the base64url encoded
+// Ed25519 signature of the encoded claims.
+func MintToken(priv ed25519.PrivateKey, c Claims) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	sig := ed25519.Sign(priv, []byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// ParseToken verifies that a token is signed by one of the operator keys and not expired, and returns its claims.
+func ParseToken(token string, keys []ed25519.PublicKey, now time.Time) (*Claims, error) {
+	payload, sig64, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed token")
+	}
+	sig, err := base64.RawURLEncoding.Strict().DecodeString(sig64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+
+	verified := false
+	for _, k := range keys {
+		if ed25519.Verify(k, []byte(payload), sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("token not signed by an operator key")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	var c Claims
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if exp := time.Unix(c.Expiry, 0); !now.Before(exp) {
+		return nil, fmt.Errorf("token expired at %s", exp.UTC().Format(time.RFC3339))
+	}
+	return &c, nil
+}
+
+// TokenAuthorizer accepts only the tunnels presenting a valid invite token signed by an operator key.
+type TokenAuthorizer struct {
+	keys []ed25519.PublicKey
+}
+
+// NewTokenAuthorizer creates a TokenAuthorizer trusting the given operator public keys.
+func NewTokenAuthorizer(keys []ed25519.PublicKey) *TokenAuthorizer {
+	return &TokenAuthorizer{keys: keys}
+}
+
+// Authorize implements Authorizer.
+func (a *TokenAuthorizer) Authorize(req Request) (Grant, error) {
+	if req.Token == "" {
+		return Grant{}, fmt.Errorf("an invite token is required")
+	}
+	c, err := ParseToken(req.Token, a.keys, time.Now())
+	if err != nil {
+		return Grant{}, err
+	}
+	if c.TunnelID != "" && c.TunnelID != req.TunnelID {
+		return Grant{}, fmt.Errorf("token is for tunnel %s", c.TunnelID)
+	}
+
+	g := Grant{MaxUplinks: c.MaxUplinks}
+	if len(c.Ports) > 0 {
+		g.Ports = c.Ports
+	}
+	return g, nil
+}
+
+// LoadPublicKeys reads base64 encoded Ed25519 public keys from a file, one per line.
+// Empty lines and lines starting with # are ignored.
+func LoadPublicKeys(path string) ([]ed25519.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []ed25519.PublicKey
+	for n, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(k) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s:%d: expecting a base64 encoded Ed25519 public key", path, n+1)
+		}
+		keys = append(keys, ed25519.PublicKey(k))
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found in %q", path)
+	}
+	return keys, nil
+}
@@ -0,0 +1,103 @@
+package authz
+
+import (
+	"crypto/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestParseToken(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	claims := Claims{Expiry: now.Add(time.Hour).Unix(), Ports: []int32{443}, MaxUplinks: 2, TunnelID: "tunnel"}
+	tok, err := MintToken(priv, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseToken(tok, []ed25519.PublicKey{otherPub, pub}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*got, claims) {
+		t.Errorf("got %+v, want %+v", *got, claims)
+	}
+
+	otherTok, err := MintToken(otherPriv, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(tok, ".")
+	forged, err := MintToken(otherPriv, Claims{Expiry: claims.Expiry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	testCases := []struct {
+		name  string
+		token string
+		now   time.Time
+	}{
+		{"expired", tok, now.Add(time.Hour)},
+		{"unknown key", otherTok, now},
+		{"malformed", "nodot", now},
+		{"bad signature encoding", payload + ".!!", now},
+		{"swapped claims", forgedPayload + "." + sig, now},
+		{"tampered signature", payload + "." + sig[:len(sig)-1] + flip(sig[len(sig)-1]), now},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if c, err := ParseToken(tc.token, []ed25519.PublicKey{pub}, tc.now); err == nil {
+				t.Errorf("expected an error, got claims %+v", c)
+			}
+		})
+	}
+}
+
+// flip returns a base64url character different from c.
+func flip(c byte) string {
+	if c == 'A' {
+		return "B"
+	}
+	return "A"
+}
+
+func TestTokenAuthorizer(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewTokenAuthorizer([]ed25519.PublicKey{pub})
+
+	tok, err := MintToken(priv, Claims{Expiry: time.Now().Add(time.Hour).Unix(), Ports: []int32{443}, MaxUplinks: 3, TunnelID: "tunnel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := a.Authorize(Request{TunnelID: "tunnel", Token: tok})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Grant{Ports: []int32{443}, MaxUplinks: 3}); !reflect.DeepEqual(g, want) {
+		t.Errorf("got %+v, want %+v", g, want)
+	}
+
+	if _, err := a.Authorize(Request{TunnelID: "other", Token: tok}); err == nil {
+		t.Error("expected a token bound to another tunnel to be rejected")
+	}
+	if _, err := a.Authorize(Request{TunnelID: "tunnel"}); err == nil {
+		t.Error("expected a missing token to be rejected")
+	}
+}
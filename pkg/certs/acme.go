@@ -0,0 +1,282 @@
+// Package certs provides sources of TLS certificates that can change while listeners are running.
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// DefaultRenewBefore is how long before expiry a certificate gets renewed.
+	DefaultRenewBefore = 30 * 24 * time.Hour
+
+	// retryInterval is how long to wait before retrying a failed renewal.
+	retryInterval = 10 * time.Minute
+	// checkInterval is how often the certificate expiry is checked.
+	checkInterval = 12 * time.Hour
+
+	accountKeyFile = "account.key"
+	certFile       = "cert.pem"
+	keyFile        = "key.pem"
+)
+
+// A ChallengeSolver fulfills ACME DNS-01 challenges by publishing TXT records
+// under _acme-challenge.<domain>.
+type ChallengeSolver interface {
+	Present(ctx context.Context, domain, value string) error
+	CleanUp(ctx context.Context, domain, value string) error
+}
+
+// ACMEManager obtains and renews a certificate through ACME DNS-01 challenges.
+// It can be plugged in a tls.Config via GetCertificate, so that renewed certificates
+// are used by live listeners.
+type ACMEManager struct {
+	client   *acme.Client
+	email    string
+	domains  []string
+	cacheDir string
+	solver   ChallengeSolver
+
+	// RenewBefore is how long before expiry the certificate gets renewed.
+	RenewBefore time.Duration
+
+	mu   sync.RWMutex // protects cert
+	cert *tls.Certificate
+}
+
+// NewACMEManager creates an ACMEManager for a list of domains (wildcards allowed) using the ACME
+// directory at directoryURL. The account key and the certificate are persisted in cacheDir.
+// If httpClient is nil, http.DefaultClient is used to talk to the ACME server.
+func NewACMEManager(directoryURL, email string, domains []string, cacheDir string, solver ChallengeSolver, httpClient *http.Client) (*ACMEManager, error) {
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, err
+	}
+	key, err := loadOrCreateKey(filepath.Join(cacheDir, accountKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &ACMEManager{
+		client: &acme.Client{
+			Key:          key,
+			DirectoryURL: directoryURL,
+			HTTPClient:   httpClient,
+		},
+		email:       email,
+		domains:     domains,
+		cacheDir:    cacheDir,
+		solver:      solver,
+		RenewBefore: DefaultRenewBefore,
+	}
+
+	if cert, err := loadKeyPair(filepath.Join(cacheDir, certFile), filepath.Join(cacheDir, keyFile)); err == nil {
+		m.cert = cert
+		setExpiry("acme", cert)
+	} else if !os.IsNotExist(err) {
+		glog.Errorf("ignoring cached certificate: %v", err)
+	}
+	return m, nil
+}
+
+// GetCertificate returns the current certificate. It's meant to be used as tls.Config.GetCertificate.
+func (m *ACMEManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, fmt.Errorf("no certificate obtained yet for %q", m.domains)
+	}
+	return m.cert, nil
+}
+
+// Run obtains the certificate and keeps renewing it until the context is done.
+func (m *ACMEManager) Run(ctx context.Context) error {
+	for {
+		wait := checkInterval
+		if m.needsRenewal() {
+			if err := m.renew(ctx); err != nil {
+				glog.Errorf("obtaining certificate for %q: %v", m.domains, err)
+				wait = retryInterval
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (m *ACMEManager) needsRenewal() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return true
+	}
+	return time.Until(m.cert.Leaf.NotAfter) < m.RenewBefore
+}
+
+// renew obtains a new certificate and swaps it in.
+func (m *ACMEManager) renew(ctx context.Context) error {
+	glog.Infof("obtaining certificate for %q", m.domains)
+
+	acct := &acme.Account{}
+	if m.email != "" {
+		acct.Contact = []string{"mailto:" + m.email}
+	}
+	if _, err := m.client.Register(ctx, acct, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return fmt.Errorf("registering ACME account: %w", err)
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.domains...))
+	if err != nil {
+		return err
+	}
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, u); err != nil {
+			return err
+		}
+	}
+	if order, err = m.client.WaitOrder(ctx, order.URI); err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: m.domains}, key)
+	if err != nil {
+		return err
+	}
+	der, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return err
+	}
+
+	cert, err := m.store(der, key)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+	setExpiry("acme", cert)
+
+	glog.Infof("obtained certificate for %q, valid until %s", m.domains, cert.Leaf.NotAfter)
+	return nil
+}
+
+// authorize fulfills the DNS-01 challenge of a pending authorization.
+func (m *ACMEManager) authorize(ctx context.Context, authzURL string) error {
+	authz, err := m.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no dns-01 challenge offered for %q", authz.Identifier.Value)
+	}
+
+	value, err := m.client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	domain := strings.TrimPrefix(authz.Identifier.Value, "*.")
+	if err := m.solver.Present(ctx, domain, value); err != nil {
+		return fmt.Errorf("presenting dns-01 challenge for %q: %w", domain, err)
+	}
+	defer m.solver.CleanUp(ctx, domain, value)
+
+	if _, err := m.client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = m.client.WaitAuthorization(ctx, authzURL)
+	return err
+}
+
+// store persists a certificate chain and its key in the cache directory and returns the parsed certificate.
+func (m *ACMEManager) store(der [][]byte, key crypto.Signer) (*tls.Certificate, error) {
+	var certPEM []byte
+	for _, b := range der {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(m.cacheDir, keyFile), keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(m.cacheDir, certFile), certPEM, 0644); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// loadOrCreateKey loads a PEM encoded private key from path, generating it if it doesn't exist.
+func loadOrCreateKey(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	p, _ := pem.Decode(b)
+	if p == nil {
+		return nil, fmt.Errorf("no PEM data in %q", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(p.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T in %q", key, path)
+	}
+	return signer, nil
+}
@@ -0,0 +1,125 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The ACME test runs against a Pebble server (https://github.com/letsencrypt/pebble), e.g.:
+//
+//	PEBBLE_VA_ALWAYS_VALID=1 pebble -config test/config/pebble-config.json
+//	UDIG_PEBBLE_DIRECTORY=https://localhost:14000/dir UDIG_PEBBLE_CA=test/certs/pebble.minica.pem go test ./pkg/certs
+//
+// PEBBLE_VA_ALWAYS_VALID makes Pebble skip the DNS lookups, so the fake solver doesn't need to serve TXT records.
+const (
+	pebbleDirectoryEnv = "UDIG_PEBBLE_DIRECTORY"
+	pebbleCAEnv        = "UDIG_PEBBLE_CA"
+)
+
+type fakeSolver struct {
+	mu        sync.Mutex
+	presented map[string]string
+	cleaned   map[string]string
+}
+
+func (s *fakeSolver) Present(ctx context.Context, domain, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.presented[domain] = value
+	return nil
+}
+
+func (s *fakeSolver) CleanUp(ctx context.Context, domain, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleaned[domain] = value
+	return nil
+}
+
+func pebbleClient(t *testing.T) *http.Client {
+	path := os.Getenv(pebbleCAEnv)
+	if path == "" {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		t.Fatalf("no certificates in %q", path)
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+}
+
+func TestACMEManager(t *testing.T) {
+	dir := os.Getenv(pebbleDirectoryEnv)
+	if dir == "" {
+		t.Skipf("%s not set", pebbleDirectoryEnv)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	domains := []string{"udig.test", "*.udig.test"}
+	solver := &fakeSolver{presented: map[string]string{}, cleaned: map[string]string{}}
+	cacheDir := t.TempDir()
+
+	m, err := NewACMEManager(dir, "admin@udig.test", domains, cacheDir, solver, pebbleClient(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetCertificate(nil); err == nil {
+		t.Fatal("expected no certificate before the first renewal")
+	}
+	if !m.needsRenewal() {
+		t.Fatal("expected a renewal to be needed without a certificate")
+	}
+
+	if err := m.renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := m.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []string{"udig.test", "foo.udig.test"} {
+		if err := cert.Leaf.VerifyHostname(h); err != nil {
+			t.Errorf("certificate doesn't cover %q: %v", h, err)
+		}
+	}
+
+	solver.mu.Lock()
+	if v, ok := solver.presented["udig.test"]; !ok || v == "" {
+		t.Errorf("challenge for %q not presented: %v", "udig.test", solver.presented)
+	}
+	for d, v := range solver.presented {
+		if solver.cleaned[d] != v {
+			t.Errorf("challenge for %q not cleaned up", d)
+		}
+	}
+	solver.mu.Unlock()
+
+	// A new manager picks up the cached certificate and account key.
+	m2, err := NewACMEManager(dir, "admin@udig.test", domains, cacheDir, solver, pebbleClient(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := m2.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached.Leaf.Equal(cert.Leaf) {
+		t.Error("cached certificate differs from the obtained one")
+	}
+	if m2.needsRenewal() {
+		t.Error("cached certificate should not need a renewal")
+	}
+}
@@ -0,0 +1,50 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	expiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "udig_certificate_expiry_timestamp_seconds",
+		Help: "Expiry time of the certificate currently served, by certificate source.",
+	}, []string{"source"})
+)
+
+func init() {
+	prometheus.MustRegister(expiry)
+}
+
+// setExpiry updates the expiry metric of a certificate source.
+func setExpiry(source string, cert *tls.Certificate) {
+	expiry.WithLabelValues(source).Set(float64(cert.Leaf.NotAfter.Unix()))
+}
+
+// loadKeyPair loads a PEM encoded certificate chain and private key, and parses the leaf certificate.
+func loadKeyPair(certPath, keyPath string) (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeyPair(certPEM, keyPEM)
+}
+
+// parseKeyPair parses a PEM encoded certificate chain and private key, including the leaf certificate.
+func parseKeyPair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
@@ -0,0 +1,63 @@
+package certs
+
+import (
+	"crypto/tls"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/mkmik/udig/pkg/filewatch"
+)
+
+// FileReloader serves a certificate loaded from PEM files and reloads it when the files change
+// or when the process receives SIGHUP.
+// It can be plugged in a tls.Config via GetCertificate, so that reloaded certificates are used by live listeners.
+type FileReloader struct {
+	name     string
+	certPath string
+	keyPath  string
+
+	// Watcher reloads the certificate; its PollInterval can be changed before calling Run.
+	*filewatch.Watcher
+
+	mu   sync.RWMutex // protects cert
+	cert *tls.Certificate
+}
+
+// NewFileReloader loads a certificate and private key from PEM files.
+// The name distinguishes the certificate in the expiry metric (e.g. "ingress", "uplink").
+func NewFileReloader(name, certPath, keyPath string) (*FileReloader, error) {
+	r := &FileReloader{
+		name:     name,
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	w, err := filewatch.New(r.reload, certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	r.Watcher = w
+	return r, nil
+}
+
+// GetCertificate returns the current certificate. It's meant to be used as tls.Config.GetCertificate.
+func (r *FileReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// reload loads the key pair and swaps it in. The current certificate is kept on error.
+func (r *FileReloader) reload() error {
+	cert, err := loadKeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.mu.Unlock()
+
+	setExpiry(r.name, cert)
+	glog.Infof("loaded certificate %q, valid until %s", r.certPath, cert.Leaf.NotAfter)
+	return nil
+}
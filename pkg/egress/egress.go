@@ -4,21 +4,56 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/golang/glog"
+	"github.com/mkmik/udig/pkg/proxyproto"
 	"github.com/mkmik/udig/pkg/tunnel"
 	"github.com/mkmik/udig/pkg/tunnel/tunnelpb"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+var (
+	streamsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "udig_egress_streams_total",
+		Help: "Number of tunneled streams, by ingress port.",
+	}, []string{"port"})
+)
+
+func init() {
+	prometheus.MustRegister(streamsTotal)
+}
+
+// Target is a local address where tunneled streams are forwarded to.
+type Target struct {
+	Addr string
+	// ProxyProtocol is the version of the PROXY protocol header sent to the target
+	// in order to convey the original client address.
+	ProxyProtocol proxyproto.Version
+}
+
+// Route selects the target of a stream.
+type Route struct {
+	// Label is the part of the host name before the tunnel ID, e.g. "api" for api.<tunnel_id>.<domain>.
+	// The empty label matches the tunnel host name itself and any label without a route of its own.
+	Label string
+	// Port is the ingress port.
+	Port int32
+}
+
 // Server is an egress tunnel server.
 type Server struct {
 	tunnelpb.UnimplementedTunnelServer
-	eaddr string
+	targets map[Route]Target
 }
 
-// NewServer creates an egress tunnel server.
-func NewServer(eaddr string) (*Server, error) {
-	return &Server{eaddr: eaddr}, nil
+// NewServer creates an egress tunnel server forwarding streams to targets, keyed by host name label and ingress port.
+func NewServer(targets map[Route]Target) (*Server, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("at least one egress target is required")
+	}
+	return &Server{targets: targets}, nil
 }
 
 type closeWriter interface {
@@ -26,8 +61,21 @@ type closeWriter interface {
 }
 
 // NewStream implements the TunnelServer gRPC interface.
+//
+// The first up frame carries the header describing the original client session.
 func (eg *Server) NewStream(s tunnelpb.Tunnel_NewStreamServer) error {
-	cli, err := net.Dial("tcp", eg.eaddr)
+	first, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	hdr := first.GetHeader()
+	if hdr == nil {
+		return fmt.Errorf("missing header in first up frame")
+	}
+	glog.Infof("new stream from %s to port %d (sni %q)", tunnel.SourceAddr(hdr), hdr.Dport, hdr.Sni)
+	streamsTotal.WithLabelValues(strconv.Itoa(int(hdr.Dport))).Inc()
+
+	cli, err := eg.dial(hdr)
 	if err != nil {
 		return err
 	}
@@ -41,15 +89,17 @@ func (eg *Server) NewStream(s tunnelpb.Tunnel_NewStreamServer) error {
 			done <- err
 		}()
 
+		up := first
 		for {
-			up, err := s.Recv()
+			glog.V(2).Infof("got: %v", up)
+			fmt.Fprintf(cli, "%s", up.Data)
+
+			up, err = s.Recv()
 			if err == io.EOF {
 				return nil
 			} else if err != nil {
 				return err
 			}
-			glog.V(2).Infof("got: %v", up)
-			fmt.Fprintf(cli, "%s", up.Data)
 		}
 	}()
 
@@ -75,3 +125,50 @@ func (eg *Server) NewStream(s tunnelpb.Tunnel_NewStreamServer) error {
 
 	return <-done
 }
+
+// target returns the target for the stream described by hdr, picked by the host name label and the ingress port.
+func (eg *Server) target(hdr *tunnelpb.Up_Header) (Target, error) {
+	label := Label(hdr.Sni, hdr.TunnelId)
+	if t, ok := eg.targets[Route{Label: label, Port: hdr.Dport}]; ok {
+		return t, nil
+	}
+	if t, ok := eg.targets[Route{Port: hdr.Dport}]; ok {
+		return t, nil
+	}
+	// the ingress port may not match when the broker is behind a port mapping load balancer;
+	// that's unambiguous if there is only one target.
+	if len(eg.targets) == 1 {
+		for _, t := range eg.targets {
+			return t, nil
+		}
+	}
+	return Target{}, fmt.Errorf("no egress target for %q on port %d", hdr.Sni, hdr.Dport)
+}
+
+// Label returns the part of a [<label>.]<tunnel_id>.<domain> host name before the tunnel ID,
+// or the empty string if there is none.
+func Label(host, tunnelID string) string {
+	host = strings.ToLower(host)
+	if i := strings.Index(host, "."+tunnelID+"."); tunnelID != "" && i != -1 {
+		return host[:i]
+	}
+	return ""
+}
+
+// dial connects to the local target serving the stream described by hdr.
+func (eg *Server) dial(hdr *tunnelpb.Up_Header) (net.Conn, error) {
+	t, err := eg.target(hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("tcp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := proxyproto.WriteHeader(conn, t.ProxyProtocol, tunnel.SourceAddr(hdr), tunnel.DestinationAddr(hdr)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sending PROXY protocol header to %q: %w", t.Addr, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,112 @@
+// Package filewatch reloads configuration files when they change or when the process receives SIGHUP.
+package filewatch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultPollInterval is how often the files are checked for changes.
+	DefaultPollInterval = 10 * time.Second
+)
+
+// Watcher calls a reload function when a set of files changes or when the process receives SIGHUP.
+type Watcher struct {
+	paths  []string
+	reload func() error
+
+	// PollInterval is how often the files are checked for changes.
+	PollInterval time.Duration
+
+	mu      sync.Mutex // protects version
+	version string
+}
+
+// New creates a Watcher of paths and loads them with reload, which is called again whenever they change.
+// reload is expected to keep the current state when it fails.
+func New(reload func() error, paths ...string) (*Watcher, error) {
+	w := &Watcher{
+		paths:        paths,
+		reload:       reload,
+		PollInterval: DefaultPollInterval,
+	}
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Run watches the files for changes and listens for SIGHUP until the context is done.
+func (w *Watcher) Run(ctx context.Context) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	t := time.NewTicker(w.PollInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-hup:
+			glog.Infof("got SIGHUP, reloading %q", w.paths)
+			if err := w.load(); err != nil {
+				glog.Errorf("reloading %q: %v", w.paths, err)
+			}
+		case <-t.C:
+			if v, err := fileVersion(w.paths); err != nil {
+				glog.Errorf("checking %q: %v", w.paths, err)
+			} else if v != w.currentVersion() {
+				glog.Infof("%q changed, reloading", w.paths)
+				if err := w.load(); err != nil {
+					glog.Errorf("reloading %q: %v", w.paths, err)
+				}
+			}
+		}
+	}
+}
+
+// load calls reload and records the version of the files it loaded.
+func (w *Watcher) load() error {
+	v, err := fileVersion(w.paths)
+	if err != nil {
+		return err
+	}
+	if err := w.reload(); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.version = v
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *Watcher) currentVersion() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.version
+}
+
+// fileVersion returns a string that changes when any of the files change.
+// Files are stat-ed through symlinks, which covers atomic updates of Kubernetes secret volumes.
+func fileVersion(paths []string) (string, error) {
+	var v string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return "", err
+		}
+		v += fmt.Sprintf("%d:%d;", fi.ModTime().UnixNano(), fi.Size())
+	}
+	return v, nil
+}
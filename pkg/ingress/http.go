@@ -0,0 +1,120 @@
+package ingress
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mkmik/udig/pkg/tunnel"
+	"github.com/mkmik/udig/pkg/uplink"
+)
+
+const (
+	// requestHeaderTimeout bounds the time we wait for a client to send the HTTP request header.
+	requestHeaderTimeout = 10 * time.Second
+	// maxRequestHeaderBytes bounds the size of the HTTP request header, as in net/http servers.
+	maxRequestHeaderBytes = http.DefaultMaxHeaderBytes
+
+	// acmeChallengePrefix is the path prefix of ACME HTTP-01 challenges, which are never redirected.
+	acmeChallengePrefix = "/.well-known/acme-challenge/"
+)
+
+// ListenHTTP listens to a port for plaintext HTTP/1.x connections, and dispatches newly accepted
+// connections to the forward channel.
+//
+// The tunnel is selected by the Host header of the first request, and the bytes read while
+// parsing it are replayed into the tunnel. If redirect is true, requests are answered with a redirect
+// to https instead, except for ACME HTTP-01 challenges which are still forwarded.
+//
+// See Listen for the meaning of proxyTrusted.
+func ListenHTTP(port int32, redirect bool, proxyTrusted []*net.IPNet, forward chan<- uplink.NewStream) error {
+	glog.Infof("listening http ingress on %d", port)
+
+	lis, err := listen(port, proxyTrusted)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			glog.Errorf("%+v", err)
+			continue
+		}
+
+		go func() {
+			req, conn, err := peekRequest(conn)
+			if err != nil {
+				glog.Errorf("reading HTTP request from %s: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			host := strings.ToLower(hostOnly(req.Host))
+
+			if redirect && !strings.HasPrefix(req.URL.Path, acmeChallengePrefix) {
+				glog.Infof("redirecting conn %p from %s for %s to https", conn, conn.RemoteAddr(), host)
+				redirectToHTTPS(conn, host, req)
+				return
+			}
+
+			glog.Infof("accepted http conn %p from %s for %s", conn, conn.RemoteAddr(), host)
+
+			forward <- uplink.NewStream{Conn: conn, Header: tunnel.HeaderFor("", host, conn)}
+		}()
+	}
+}
+
+// peekRequest reads the header of the first HTTP request sent over conn and returns
+// it together with a connection that replays the bytes consumed while peeking.
+func peekRequest(conn net.Conn) (*http.Request, net.Conn, error) {
+	var buf bytes.Buffer
+
+	conn.SetReadDeadline(time.Now().Add(requestHeaderTimeout))
+	req, err := http.ReadRequest(bufio.NewReader(io.TeeReader(io.LimitReader(conn, maxRequestHeaderBytes), &buf)))
+	conn.SetReadDeadline(time.Time{})
+
+	if err != nil {
+		if buf.Len() >= maxRequestHeaderBytes {
+			return nil, conn, fmt.Errorf("request header larger than %d bytes", maxRequestHeaderBytes)
+		}
+		return nil, conn, err
+	}
+	if req.Host == "" {
+		return nil, conn, fmt.Errorf("missing Host header")
+	}
+	return req, &prefixConn{Conn: conn, r: io.MultiReader(&buf, conn)}, nil
+}
+
+// redirectToHTTPS answers req with a permanent redirect to the https version of the URL and closes conn.
+func redirectToHTTPS(conn net.Conn, host string, req *http.Request) {
+	defer conn.Close()
+
+	resp := &http.Response{
+		StatusCode: http.StatusMovedPermanently,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Location":   []string{"https://" + host + req.URL.RequestURI()},
+			"Connection": []string{"close"},
+		},
+		ContentLength: 0,
+		Close:         true,
+	}
+	if err := resp.Write(conn); err != nil {
+		glog.Errorf("writing redirect to %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// hostOnly strips the optional port from a Host header value.
+func hostOnly(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return hostport
+}
@@ -0,0 +1,84 @@
+package ingress
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPeekRequest(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		wantHost string
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "host",
+			input:    "GET /index.html?q=1 HTTP/1.1\r\nHost: Foo.Tunnel.udig.test:8080\r\nUser-Agent: test\r\n\r\n",
+			wantHost: "Foo.Tunnel.udig.test:8080",
+			wantPath: "/index.html",
+		},
+		{
+			name:     "body and pipelined request",
+			input:    "POST /upload HTTP/1.1\r\nHost: foo.udig.test\r\nContent-Length: 5\r\n\r\nhelloGET / HTTP/1.1\r\nHost: foo.udig.test\r\n\r\n",
+			wantHost: "foo.udig.test",
+			wantPath: "/upload",
+		},
+		{
+			name:     "absolute URI",
+			input:    "GET http://bar.udig.test/x HTTP/1.1\r\nHost: foo.udig.test\r\n\r\n",
+			wantHost: "bar.udig.test",
+			wantPath: "/x",
+		},
+		{name: "missing host", input: "GET / HTTP/1.0\r\nUser-Agent: test\r\n\r\n", wantErr: true},
+		{name: "empty host", input: "GET / HTTP/1.1\r\nHost:\r\n\r\n", wantErr: true},
+		{name: "oversized host", input: "GET / HTTP/1.1\r\nHost: " + strings.Repeat("a", maxRequestHeaderBytes) + "\r\n\r\n", wantErr: true},
+		{name: "truncated", input: "GET / HTTP/1.1\r\nHost: foo.udig.test\r\n", wantErr: true},
+		{name: "not http", input: "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, conn, err := peekRequest(pipeConn([]byte(tc.input)))
+			defer conn.Close()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got host %q", req.Host)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Host != tc.wantHost {
+				t.Errorf("got host %q, want %q", req.Host, tc.wantHost)
+			}
+			if req.URL.Path != tc.wantPath {
+				t.Errorf("got path %q, want %q", req.URL.Path, tc.wantPath)
+			}
+
+			// the peeked bytes are replayed unchanged, followed by the rest of the stream.
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, []byte(tc.input)) {
+				t.Errorf("replayed %q, want %q", got, tc.input)
+			}
+		})
+	}
+}
+
+func TestHostOnly(t *testing.T) {
+	for in, want := range map[string]string{
+		"foo.udig.test":      "foo.udig.test",
+		"foo.udig.test:8080": "foo.udig.test",
+		"[2001:db8::1]:80":   "2001:db8::1",
+	} {
+		if got := hostOnly(in); got != want {
+			t.Errorf("hostOnly(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
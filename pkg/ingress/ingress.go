@@ -3,10 +3,13 @@ package ingress
 import (
 	"crypto/tls"
 	"fmt"
+	"net"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/mkmik/udig/pkg/proxyproto"
+	"github.com/mkmik/udig/pkg/tunnel"
 	"github.com/mkmik/udig/pkg/uplink"
 )
 
@@ -15,8 +18,28 @@ var (
 	DefaultPorts = []int32{443}
 )
 
+const (
+	// acmeALPNProto is the ALPN protocol negotiated by ACME TLS-ALPN-01 challenges.
+	acmeALPNProto = "acme-tls/1"
+
+	// handshakeTimeout bounds the time a client has to complete the TLS handshake.
+	handshakeTimeout = 10 * time.Second
+)
+
 // ParsePorts parses a list port numbers and returns DefaultPorts if empty.
 func ParsePorts(portStrings []string) ([]int32, error) {
+	res, err := ParsePortList(portStrings)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		res = DefaultPorts
+	}
+	return res, nil
+}
+
+// ParsePortList parses a list port numbers.
+func ParsePortList(portStrings []string) ([]int32, error) {
 	var res []int32
 	for _, p := range portStrings {
 		i, err := strconv.Atoi(p)
@@ -25,25 +48,25 @@ func ParsePorts(portStrings []string) ([]int32, error) {
 		}
 		res = append(res, int32(i))
 	}
-	if len(res) == 0 {
-		res = DefaultPorts
-	}
 	return res, nil
 }
 
 // Listen listens to a port, and dispatches newly accepted connections to the forward channel.
 //
-// TLS termination is done here and the SNI name is passed to the uplink.NewStream structure.
-func Listen(port int32, cert tls.Certificate, forward chan<- uplink.NewStream) error {
+// TLS termination is done here and the SNI name is passed to the uplink.NewStream structure,
+// from which the router resolves the tunnel ID.
+// The TLS config can provide certificates dynamically via GetCertificate.
+//
+// If proxyTrusted is not empty, connections coming from those networks must start with a
+// PROXY protocol header, whose client address replaces the address of the connection peer.
+func Listen(port int32, cfg *tls.Config, proxyTrusted []*net.IPNet, forward chan<- uplink.NewStream) error {
 	glog.Infof("listening ingress on %d", port)
 
-	cfg := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-	}
-	lis, err := tls.Listen("tcp", fmt.Sprintf(":%d", port), cfg)
+	lis, err := listen(port, proxyTrusted)
 	if err != nil {
 		glog.Fatalf("%+v", err)
 	}
+	lis = tls.NewListener(lis, cfg)
 
 	for {
 		conn, err := lis.Accept()
@@ -51,18 +74,44 @@ func Listen(port int32, cert tls.Certificate, forward chan<- uplink.NewStream) e
 			glog.Errorf("%+v", err)
 			continue
 		}
-		t := conn.(*tls.Conn)
 
-		// explicit hanshake is needed because we need to read the SNI value
-		// out of the connection state before doing any read/write operation.
-		if err := t.Handshake(); err != nil {
-			glog.Errorf("%+v", err)
-			continue
-		}
+		// the handshake runs in its own goroutine, so that slow clients don't hold up the accept loop.
+		go func() {
+			t := conn.(*tls.Conn)
+
+			// explicit hanshake is needed because we need to read the SNI value
+			// out of the connection state before doing any read/write operation.
+			t.SetDeadline(time.Now().Add(handshakeTimeout))
+			err := t.Handshake()
+			t.SetDeadline(time.Time{})
+			if err != nil {
+				glog.Errorf("%+v", err)
+				conn.Close()
+				return
+			}
 
-		glog.Infof("accepted conn %p from %s for %s", conn, conn.RemoteAddr(), t.ConnectionState().ServerName)
+			// ACME TLS-ALPN-01 validation handshakes are answered by the TLS config and carry no payload.
+			if t.ConnectionState().NegotiatedProtocol == acmeALPNProto {
+				conn.Close()
+				return
+			}
 
-		c := strings.SplitN(t.ConnectionState().ServerName, ".", 2)
-		forward <- uplink.NewStream{TunnelID: c[0], Conn: conn}
+			glog.Infof("accepted conn %p from %s for %s", conn, conn.RemoteAddr(), t.ConnectionState().ServerName)
+
+			forward <- uplink.NewStream{Conn: conn, Header: tunnel.HeaderFor("", t.ConnectionState().ServerName, conn)}
+		}()
+	}
+}
+
+// listen listens to a TCP port, optionally accepting PROXY protocol headers from trusted networks.
+func listen(port int32, proxyTrusted []*net.IPNet) (net.Listener, error) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	if len(proxyTrusted) > 0 {
+		glog.Infof("accepting PROXY protocol on %d from %v", port, proxyTrusted)
+		lis = proxyproto.NewListener(lis, proxyTrusted)
 	}
+	return lis, nil
 }
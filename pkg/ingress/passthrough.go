@@ -0,0 +1,110 @@
+package ingress
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mkmik/udig/pkg/tunnel"
+	"github.com/mkmik/udig/pkg/uplink"
+)
+
+const (
+	// clientHelloTimeout bounds the time we wait for a client to send the TLS ClientHello.
+	clientHelloTimeout = 10 * time.Second
+)
+
+// ListenPassthrough listens to a port, and dispatches newly accepted connections to the forward channel.
+//
+// TLS is not terminated here: the ClientHello is peeked in order to extract the SNI name, and the raw
+// TLS stream (including the ClientHello bytes already read) is forwarded to the uplink.
+//
+// See Listen for the meaning of proxyTrusted.
+func ListenPassthrough(port int32, proxyTrusted []*net.IPNet, forward chan<- uplink.NewStream) error {
+	glog.Infof("listening passthrough ingress on %d", port)
+
+	lis, err := listen(port, proxyTrusted)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			glog.Errorf("%+v", err)
+			continue
+		}
+
+		go func() {
+			serverName, conn, err := peekServerName(conn)
+			if err != nil {
+				glog.Errorf("reading ClientHello from %s: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+
+			glog.Infof("accepted passthrough conn %p from %s for %s", conn, conn.RemoteAddr(), serverName)
+
+			forward <- uplink.NewStream{Conn: conn, Header: tunnel.HeaderFor("", serverName, conn), Passthrough: true}
+		}()
+	}
+}
+
+// peekServerName reads the TLS ClientHello from conn and returns the SNI server name
+// together with a connection that replays the bytes consumed while peeking.
+func peekServerName(conn net.Conn) (string, net.Conn, error) {
+	var buf bytes.Buffer
+
+	conn.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+	hello, err := readClientHello(io.TeeReader(conn, &buf))
+	conn.SetReadDeadline(time.Time{})
+
+	if err != nil {
+		return "", conn, err
+	}
+	if hello.ServerName == "" {
+		return "", conn, fmt.Errorf("missing SNI")
+	}
+	return hello.ServerName, &prefixConn{Conn: conn, r: io.MultiReader(&buf, conn)}, nil
+}
+
+// readClientHello parses a TLS ClientHello by starting a server side handshake
+// on a read-only connection and aborting it as soon as the hello has been parsed.
+func readClientHello(r io.Reader) (*tls.ClientHelloInfo, error) {
+	var hello *tls.ClientHelloInfo
+	err := tls.Server(readOnlyConn{r: r}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = new(tls.ClientHelloInfo)
+			*hello = *h
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if hello == nil {
+		return nil, err
+	}
+	return hello, nil
+}
+
+var errHelloRead = fmt.Errorf("ClientHello read")
+
+// readOnlyConn is a net.Conn that reads from r and fails all writes.
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                { return nil }
+
+// prefixConn is a net.Conn whose reads are served from r.
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) { return c.r.Read(p) }
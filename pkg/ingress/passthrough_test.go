@@ -0,0 +1,83 @@
+package ingress
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// clientHello returns the first TLS record sent by a client connecting to serverName.
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	go tls.Client(c, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+
+	hdr := make([]byte, 5)
+	if _, err := io.ReadFull(s, hdr); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[3:]))
+	if _, err := io.ReadFull(s, body); err != nil {
+		t.Fatal(err)
+	}
+	return append(hdr, body...)
+}
+
+// pipeConn returns a connection from which the given bytes can be read, followed by EOF.
+func pipeConn(b []byte) net.Conn {
+	c, s := net.Pipe()
+	go func() {
+		s.Write(b)
+		s.Close()
+	}()
+	return c
+}
+
+func TestPeekServerName(t *testing.T) {
+	trailer := []byte("more client data")
+
+	testCases := []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "sni", input: clientHello(t, "foo.tunnel.udig.test"), want: "foo.tunnel.udig.test"},
+		{name: "missing sni", input: clientHello(t, ""), wantErr: true},
+		{name: "not tls", input: []byte("GET / HTTP/1.1\r\nHost: foo\r\n\r\n"), wantErr: true},
+		{name: "truncated", input: clientHello(t, "foo.udig.test")[:40], wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input := append(append([]byte(nil), tc.input...), trailer...)
+			name, conn, err := peekServerName(pipeConn(input))
+			defer conn.Close()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tc.want {
+				t.Errorf("got server name %q, want %q", name, tc.want)
+			}
+
+			// the peeked ClientHello is replayed unchanged, followed by the rest of the stream.
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, input) {
+				t.Errorf("replayed %d bytes, want the %d bytes sent by the client", len(got), len(input))
+			}
+		})
+	}
+}
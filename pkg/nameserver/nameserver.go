@@ -0,0 +1,336 @@
+// Package nameserver implements an authoritative DNS server for tunnel host names.
+package nameserver
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// TTL is the time to live of positive answers.
+	TTL = 60
+	// NegativeTTL is the time to live of negative answers (the SOA minimum field).
+	// It's short because tunnels come and go.
+	NegativeTTL = 10
+	// ChallengeTTL is the time to live of ACME challenge TXT records.
+	ChallengeTTL = 1
+
+	maxUDPSize = 512
+	tcpTimeout = 10 * time.Second
+)
+
+// A TunnelChecker reports whether a tunnel has any active uplink.
+type TunnelChecker interface {
+	HasTunnel(tunnelID string) bool
+}
+
+// Server is an authoritative DNS server answering for <tunnel_id>.<domain>
+// and *.<tunnel_id>.<domain> with the public addresses of the broker.
+type Server struct {
+	domain  string // lowercase and fully qualified
+	addrs   []net.IP
+	tunnels TunnelChecker
+
+	mu  sync.RWMutex        // protects txt
+	txt map[string][]string // TXT records, by lowercase fully qualified name
+}
+
+// NewServer creates a DNS server authoritative for domain.
+func NewServer(domain string, addrs []net.IP, tunnels TunnelChecker) (*Server, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("at least one public address is required")
+	}
+	return &Server{
+		domain:  fqdn(domain),
+		addrs:   addrs,
+		tunnels: tunnels,
+		txt:     map[string][]string{},
+	}, nil
+}
+
+// Present publishes an ACME DNS-01 challenge record for domain.
+// It allows the Server to be used as an ACME challenge solver.
+func (s *Server) Present(ctx context.Context, domain, value string) error {
+	name := fqdn("_acme-challenge." + domain)
+	if !strings.HasSuffix(name, "."+s.domain) {
+		return fmt.Errorf("%q is not within %q", domain, s.domain)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.txt[name] = append(s.txt[name], value)
+	return nil
+}
+
+// CleanUp removes an ACME DNS-01 challenge record previously published with Present.
+func (s *Server) CleanUp(ctx context.Context, domain, value string) error {
+	name := fqdn("_acme-challenge." + domain)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []string
+	for _, v := range s.txt[name] {
+		if v != value {
+			res = append(res, v)
+		}
+	}
+	if len(res) == 0 {
+		delete(s.txt, name)
+	} else {
+		s.txt[name] = res
+	}
+	return nil
+}
+
+// ListenAndServe serves DNS over both UDP and TCP on addr.
+func (s *Server) ListenAndServe(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer lis.Close()
+
+	glog.Infof("serving DNS for %q on %s", s.domain, addr)
+
+	errCh := make(chan error, 2)
+	go func() { errCh <- s.serveUDP(pc) }()
+	go func() { errCh <- s.serveTCP(lis) }()
+	return <-errCh
+}
+
+func (s *Server) serveUDP(pc net.PacketConn) error {
+	buf := make([]byte, maxUDPSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		resp, err := s.handle(buf[:n], maxUDPSize)
+		if err != nil {
+			glog.V(2).Infof("bad DNS query from %s: %v", addr, err)
+			continue
+		}
+		if _, err := pc.WriteTo(resp, addr); err != nil {
+			glog.Errorf("writing DNS response to %s: %v", addr, err)
+		}
+	}
+}
+
+func (s *Server) serveTCP(lis net.Listener) error {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.serveTCPConn(conn); err != nil && err != io.EOF {
+				glog.V(2).Infof("DNS over TCP from %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCPConn(conn net.Conn) error {
+	for {
+		conn.SetDeadline(time.Now().Add(tcpTimeout))
+
+		var l uint16
+		if err := binary.Read(conn, binary.BigEndian, &l); err != nil {
+			return err
+		}
+		req := make([]byte, l)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return err
+		}
+		resp, err := s.handle(req, 0)
+		if err != nil {
+			return err
+		}
+		out := make([]byte, 2, 2+len(resp))
+		binary.BigEndian.PutUint16(out, uint16(len(resp)))
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers a packed DNS query. If maxSize is not zero and the response
+// doesn't fit, a truncated response is returned.
+func (s *Server) handle(req []byte, maxSize int) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(req)
+	if err != nil {
+		return nil, err
+	}
+	if h.Response {
+		return nil, fmt.Errorf("not a query")
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := s.answer(h, q)
+	out, err := resp.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && len(out) > maxSize {
+		resp.Header.Truncated = true
+		resp.Answers, resp.Authorities = nil, nil
+		return resp.Pack()
+	}
+	return out, nil
+}
+
+// answer builds the response to a single question.
+func (s *Server) answer(h dnsmessage.Header, q dnsmessage.Question) *dnsmessage.Message {
+	resp := &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               h.ID,
+			Response:         true,
+			OpCode:           h.OpCode,
+			RecursionDesired: h.RecursionDesired,
+		},
+		Questions: []dnsmessage.Question{q},
+	}
+	if h.OpCode != 0 {
+		resp.Header.RCode = dnsmessage.RCodeNotImplemented
+		return resp
+	}
+
+	name := strings.ToLower(q.Name.String())
+	if name != s.domain && !strings.HasSuffix(name, "."+s.domain) {
+		resp.Header.RCode = dnsmessage.RCodeRefused
+		return resp
+	}
+	resp.Header.Authoritative = true
+
+	if !s.exists(name) {
+		glog.V(2).Infof("DNS %s %s: NXDOMAIN", q.Type, name)
+		resp.Header.RCode = dnsmessage.RCodeNameError
+		resp.Authorities = []dnsmessage.Resource{s.soa()}
+		return resp
+	}
+
+	switch q.Type {
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA, dnsmessage.TypeALL:
+		resp.Answers = s.addresses(q.Name, q.Type)
+	case dnsmessage.TypeTXT:
+		resp.Answers = s.texts(q.Name, name)
+	case dnsmessage.TypeNS:
+		if name == s.domain {
+			resp.Answers = []dnsmessage.Resource{s.ns()}
+		}
+	case dnsmessage.TypeSOA:
+		if name == s.domain {
+			resp.Answers = []dnsmessage.Resource{s.soa()}
+		}
+	}
+	if len(resp.Answers) == 0 {
+		resp.Authorities = []dnsmessage.Resource{s.soa()}
+	}
+	glog.V(2).Infof("DNS %s %s: %d answers", q.Type, name, len(resp.Answers))
+	return resp
+}
+
+// exists returns true if name (a fully qualified name within our domain) exists.
+func (s *Server) exists(name string) bool {
+	if name == s.domain || s.hasTXT(name) {
+		return true
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."+s.domain), ".")
+	last := labels[len(labels)-1]
+	if len(labels) == 1 && (last == "ns" || last == "uplink") {
+		return true
+	}
+	return s.tunnels.HasTunnel(last)
+}
+
+func (s *Server) addresses(name dnsmessage.Name, typ dnsmessage.Type) []dnsmessage.Resource {
+	var res []dnsmessage.Resource
+	for _, ip := range s.addrs {
+		if ip4 := ip.To4(); ip4 != nil {
+			if typ == dnsmessage.TypeAAAA {
+				continue
+			}
+			r := &dnsmessage.AResource{}
+			copy(r.A[:], ip4)
+			res = append(res, dnsmessage.Resource{Header: s.header(name, dnsmessage.TypeA, TTL), Body: r})
+		} else {
+			if typ == dnsmessage.TypeA {
+				continue
+			}
+			r := &dnsmessage.AAAAResource{}
+			copy(r.AAAA[:], ip.To16())
+			res = append(res, dnsmessage.Resource{Header: s.header(name, dnsmessage.TypeAAAA, TTL), Body: r})
+		}
+	}
+	return res
+}
+
+func (s *Server) hasTXT(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.txt[name]) > 0
+}
+
+func (s *Server) texts(qname dnsmessage.Name, name string) []dnsmessage.Resource {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var res []dnsmessage.Resource
+	for _, v := range s.txt[name] {
+		res = append(res, dnsmessage.Resource{
+			Header: s.header(qname, dnsmessage.TypeTXT, ChallengeTTL),
+			Body:   &dnsmessage.TXTResource{TXT: []string{v}},
+		})
+	}
+	return res
+}
+
+func (s *Server) ns() dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: s.header(dnsmessage.MustNewName(s.domain), dnsmessage.TypeNS, TTL),
+		Body:   &dnsmessage.NSResource{NS: dnsmessage.MustNewName("ns." + s.domain)},
+	}
+}
+
+func (s *Server) soa() dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: s.header(dnsmessage.MustNewName(s.domain), dnsmessage.TypeSOA, NegativeTTL),
+		Body: &dnsmessage.SOAResource{
+			NS:      dnsmessage.MustNewName("ns." + s.domain),
+			MBox:    dnsmessage.MustNewName("hostmaster." + s.domain),
+			Serial:  1,
+			Refresh: 3600,
+			Retry:   600,
+			Expire:  86400,
+			MinTTL:  NegativeTTL,
+		},
+	}
+}
+
+func (s *Server) header(name dnsmessage.Name, typ dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: ttl}
+}
+
+func fqdn(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".") + ".")
+}
@@ -0,0 +1,182 @@
+package nameserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const testTunnel = "bahwqceraql2zswv4ot7pmpokaci76h7umqj4t2qtpft3snyv5dsfg73mji4q"
+
+type tunnelSet map[string]bool
+
+func (ts tunnelSet) HasTunnel(tunnelID string) bool { return ts[tunnelID] }
+
+// query sends a query through handle and parses the response.
+func query(t *testing.T, s *Server, name string, typ dnsmessage.Type, maxSize int) *dnsmessage.Message {
+	t.Helper()
+	q := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}},
+	}
+	req, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := s.handle(req, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp dnsmessage.Message
+	if err := resp.Unpack(out); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.ID != 42 || !resp.Header.Response {
+		t.Fatalf("bad response header %+v", resp.Header)
+	}
+	return &resp
+}
+
+// summary renders the answers of a response in a compact form.
+func summary(rs []dnsmessage.Resource) []string {
+	var res []string
+	for _, r := range rs {
+		switch b := r.Body.(type) {
+		case *dnsmessage.AResource:
+			res = append(res, fmt.Sprintf("A %s", net.IP(b.A[:])))
+		case *dnsmessage.AAAAResource:
+			res = append(res, fmt.Sprintf("AAAA %s", net.IP(b.AAAA[:])))
+		case *dnsmessage.TXTResource:
+			res = append(res, fmt.Sprintf("TXT %s", b.TXT))
+		case *dnsmessage.NSResource:
+			res = append(res, fmt.Sprintf("NS %s", b.NS))
+		case *dnsmessage.SOAResource:
+			res = append(res, fmt.Sprintf("SOA %s", b.NS))
+		}
+	}
+	return res
+}
+
+func newTestServer(t *testing.T, addrs ...string) *Server {
+	t.Helper()
+	var ips []net.IP
+	for _, a := range addrs {
+		ips = append(ips, net.ParseIP(a))
+	}
+	s, err := NewServer("Udig.Test", ips, tunnelSet{testTunnel: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAnswer(t *testing.T) {
+	s := newTestServer(t, "203.0.113.1", "2001:db8::1")
+
+	testCases := []struct {
+		name        string
+		typ         dnsmessage.Type
+		rcode       dnsmessage.RCode
+		answers     []string
+		authorities []string
+	}{
+		{name: testTunnel + ".udig.test.", typ: dnsmessage.TypeA, answers: []string{"A 203.0.113.1"}},
+		{name: testTunnel + ".udig.test.", typ: dnsmessage.TypeAAAA, answers: []string{"AAAA 2001:db8::1"}},
+		{name: "x." + testTunnel + ".UDIG.test.", typ: dnsmessage.TypeA, answers: []string{"A 203.0.113.1"}},
+		{name: "x." + testTunnel + ".udig.test.", typ: dnsmessage.TypeAAAA, answers: []string{"AAAA 2001:db8::1"}},
+		{name: testTunnel + ".udig.test.", typ: dnsmessage.TypeMX, authorities: []string{"SOA ns.udig.test."}},
+		{name: "unknown.udig.test.", typ: dnsmessage.TypeA, rcode: dnsmessage.RCodeNameError, authorities: []string{"SOA ns.udig.test."}},
+		{name: "x.unknown.udig.test.", typ: dnsmessage.TypeAAAA, rcode: dnsmessage.RCodeNameError, authorities: []string{"SOA ns.udig.test."}},
+		{name: "example.com.", typ: dnsmessage.TypeA, rcode: dnsmessage.RCodeRefused},
+		{name: "notudig.test.", typ: dnsmessage.TypeA, rcode: dnsmessage.RCodeRefused},
+		{name: "udig.test.", typ: dnsmessage.TypeNS, answers: []string{"NS ns.udig.test."}},
+		{name: "udig.test.", typ: dnsmessage.TypeSOA, answers: []string{"SOA ns.udig.test."}},
+		{name: "ns.udig.test.", typ: dnsmessage.TypeA, answers: []string{"A 203.0.113.1"}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %s", tc.typ, tc.name), func(t *testing.T) {
+			resp := query(t, s, tc.name, tc.typ, maxUDPSize)
+			if resp.Header.RCode != tc.rcode {
+				t.Errorf("got rcode %s, want %s", resp.Header.RCode, tc.rcode)
+			}
+			if got := resp.Header.Authoritative; got != (tc.rcode != dnsmessage.RCodeRefused) {
+				t.Errorf("got authoritative %v", got)
+			}
+			if got := summary(resp.Answers); fmt.Sprint(got) != fmt.Sprint(tc.answers) {
+				t.Errorf("got answers %q, want %q", got, tc.answers)
+			}
+			if got := summary(resp.Authorities); fmt.Sprint(got) != fmt.Sprint(tc.authorities) {
+				t.Errorf("got authorities %q, want %q", got, tc.authorities)
+			}
+		})
+	}
+}
+
+func TestChallengeTXT(t *testing.T) {
+	s := newTestServer(t, "203.0.113.1")
+	ctx := context.Background()
+	name := "_acme-challenge.udig.test."
+
+	if resp := query(t, s, name, dnsmessage.TypeTXT, maxUDPSize); resp.Header.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("got rcode %s before Present, want NXDOMAIN", resp.Header.RCode)
+	}
+
+	// the wildcard and the apex certificates have their challenges under the same name.
+	for _, v := range []string{"one", "two"} {
+		if err := s.Present(ctx, "udig.test", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp := query(t, s, name, dnsmessage.TypeTXT, maxUDPSize)
+	if got, want := summary(resp.Answers), []string{"TXT [one]", "TXT [two]"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if ttl := resp.Answers[0].Header.TTL; ttl != ChallengeTTL {
+		t.Errorf("got TTL %d, want %d", ttl, ChallengeTTL)
+	}
+
+	if err := s.CleanUp(ctx, "udig.test", "one"); err != nil {
+		t.Fatal(err)
+	}
+	resp = query(t, s, name, dnsmessage.TypeTXT, maxUDPSize)
+	if got, want := summary(resp.Answers), []string{"TXT [two]"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := s.CleanUp(ctx, "udig.test", "two"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := query(t, s, name, dnsmessage.TypeTXT, maxUDPSize); resp.Header.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("got rcode %s after CleanUp, want NXDOMAIN", resp.Header.RCode)
+	}
+
+	if err := s.Present(ctx, "example.com", "x"); err == nil {
+		t.Error("expected Present outside the zone to fail")
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	var addrs []string
+	for i := 1; i <= 40; i++ {
+		addrs = append(addrs, fmt.Sprintf("203.0.113.%d", i))
+	}
+	s := newTestServer(t, addrs...)
+	name := testTunnel + ".udig.test."
+
+	resp := query(t, s, name, dnsmessage.TypeA, maxUDPSize)
+	if !resp.Header.Truncated || len(resp.Answers) != 0 {
+		t.Errorf("got truncated %v with %d answers over UDP, want a truncated empty response", resp.Header.Truncated, len(resp.Answers))
+	}
+	if len(resp.Questions) != 1 {
+		t.Errorf("got %d questions, want the question echoed", len(resp.Questions))
+	}
+
+	// no size limit over TCP.
+	resp = query(t, s, name, dnsmessage.TypeA, 0)
+	if resp.Header.Truncated || len(resp.Answers) != len(addrs) {
+		t.Errorf("got truncated %v with %d answers over TCP, want %d answers", resp.Header.Truncated, len(resp.Answers), len(addrs))
+	}
+}
@@ -0,0 +1,53 @@
+// Package placement decides which tunnel broker instance should host the uplinks of a tunnel.
+package placement
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// A Policy decides whether a tunnel belongs to this broker instance.
+type Policy interface {
+	// Redirect returns the uplink addresses of the brokers the tunnel should be set up on instead, in order
+	// of preference, or nil if the tunnel belongs to this broker.
+	Redirect(tunnelID string) []string
+}
+
+// Rendezvous spreads tunnels across broker shards with rendezvous (highest random weight) hashing:
+// each tunnel belongs to the shard with the highest hash of shard address and tunnel ID. Adding or removing
+// a shard only moves the tunnels that belonged to it (or now belong to it).
+type Rendezvous struct {
+	self   string
+	shards []string
+}
+
+// NewRendezvous creates a Rendezvous policy for the broker whose uplink address is self, among the shards uplink addresses.
+func NewRendezvous(self string, shards []string) (*Rendezvous, error) {
+	for _, s := range shards {
+		if s == self {
+			return &Rendezvous{self: self, shards: shards}, nil
+		}
+	}
+	return nil, fmt.Errorf("shard %q is not in the shard list %q", self, shards)
+}
+
+// Redirect implements Policy. Only the owner shard is returned: the other shards would redirect the uplink
+// to the owner again, so they are no use as fallbacks while the owner is unreachable.
+func (r *Rendezvous) Redirect(tunnelID string) []string {
+	owner, best := "", uint64(0)
+	for _, s := range r.shards {
+		if w := weight(s, tunnelID); owner == "" || w > best {
+			owner, best = s, w
+		}
+	}
+	if owner == r.self {
+		return nil
+	}
+	return []string{owner}
+}
+
+func weight(shard, tunnelID string) uint64 {
+	h := sha256.Sum256([]byte(shard + "\x00" + tunnelID))
+	return binary.BigEndian.Uint64(h[:8])
+}
@@ -0,0 +1,226 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxV1HeaderLen is the maximum length of a v1 header, including the CRLF.
+	maxV1HeaderLen = 107
+	// maxV2BodyLen bounds the length of the v2 address block, including TLVs. The protocol allows
+	// up to 64KiB, but proxies send much less than this.
+	maxV2BodyLen = 1024
+)
+
+// ReadHeader reads a PROXY protocol v1 or v2 header and returns the source and destination
+// addresses it carries. The addresses are nil if the header doesn't convey them
+// (v1 "UNKNOWN" or v2 "LOCAL" headers).
+func ReadHeader(r *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	p, err := r.Peek(len(signature))
+	if err == nil && bytes.Equal(p, signature) {
+		return readHeaderV2(r)
+	}
+	p, err = r.Peek(6)
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(p) == "PROXY " {
+		return readHeaderV1(r)
+	}
+	return nil, nil, fmt.Errorf("missing PROXY protocol header")
+}
+
+func readHeaderV1(r *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxV1HeaderLen {
+			return nil, nil, fmt.Errorf("PROXY protocol v1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+	}
+
+	f := strings.Fields(string(line))
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("bad PROXY protocol v1 header %q", line)
+	}
+	if src, err = parseAddr(f[2], f[4]); err != nil {
+		return nil, nil, err
+	}
+	if dst, err = parseAddr(f[3], f[5]); err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("bad PROXY protocol address %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad PROXY protocol port %q: %w", port, err)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readHeaderV2(r *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	var h struct {
+		Signature [12]byte
+		VerCmd    byte
+		Family    byte
+		Len       uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return nil, nil, err
+	}
+	if h.VerCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol version %d", h.VerCmd>>4)
+	}
+	if h.Len > maxV2BodyLen {
+		return nil, nil, fmt.Errorf("PROXY protocol v2 header too long (%d bytes)", h.Len)
+	}
+	body := make([]byte, h.Len)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	switch cmd := h.VerCmd & 0xf; cmd {
+	case 0x0: // LOCAL
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol v2 command %d", cmd)
+	}
+
+	var ipLen int
+	switch h.Family {
+	case 0x11: // TCP over IPv4
+		ipLen = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLen = net.IPv6len
+	default:
+		// other families (UDP, unix sockets) carry no TCP address.
+		return nil, nil, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, fmt.Errorf("PROXY protocol v2 address block too short")
+	}
+	src = &net.TCPAddr{IP: net.IP(body[:ipLen]), Port: int(binary.BigEndian.Uint16(body[2*ipLen:]))}
+	dst = &net.TCPAddr{IP: net.IP(body[ipLen : 2*ipLen]), Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:]))}
+	return src, dst, nil
+}
+
+// Listener wraps a net.Listener and parses the PROXY protocol header sent by
+// connections accepted from trusted networks. Connections from other sources are
+// returned unchanged.
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+// NewListener returns a Listener accepting PROXY protocol headers from the trusted networks.
+func NewListener(lis net.Listener, trusted []*net.IPNet) *Listener {
+	return &Listener{Listener: lis, trusted: trusted}
+}
+
+// Accept implements net.Listener.
+//
+// The header is parsed lazily on the first Read, RemoteAddr or LocalAddr call, so that a slow
+// client cannot block the accept loop. Read deadlines set on the connection apply to the header.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	a, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(a.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection starting with a PROXY protocol header.
+// RemoteAddr and LocalAddr return the addresses conveyed by the header.
+type Conn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once     sync.Once
+	src, dst *net.TCPAddr
+	err      error
+}
+
+func (c *Conn) readHeader() error {
+	c.once.Do(func() {
+		c.src, c.dst, c.err = ReadHeader(c.r)
+		if c.err != nil {
+			c.err = fmt.Errorf("reading PROXY protocol header from %s: %w", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+	return c.err
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr implements net.Conn.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.readHeader() == nil && c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr implements net.Conn.
+func (c *Conn) LocalAddr() net.Addr {
+	if c.readHeader() == nil && c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ParseNetworks parses a list of CIDR network addresses.
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	var res []*net.IPNet
+	for _, s := range cidrs {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
@@ -0,0 +1,101 @@
+// Package proxyproto implements the HAProxy PROXY protocol, which conveys the
+// original client address to a backend behind a proxy.
+//
+// See https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Version is a PROXY protocol version.
+type Version int
+
+const (
+	// None means no PROXY protocol header.
+	None Version = 0
+	// V1 is the human readable PROXY protocol version.
+	V1 Version = 1
+	// V2 is the binary PROXY protocol version.
+	V2 Version = 2
+)
+
+// signature is the PROXY protocol v2 header prefix.
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ParseVersion parses a PROXY protocol version name ("v1" or "v2").
+func ParseVersion(s string) (Version, error) {
+	switch s {
+	case "v1":
+		return V1, nil
+	case "v2":
+		return V2, nil
+	default:
+		return None, fmt.Errorf("unknown PROXY protocol version %q", s)
+	}
+}
+
+// WriteHeader writes a PROXY protocol header of a given version for a TCP connection
+// from src to dst. If the addresses are unknown, a header without address information is written.
+func WriteHeader(w io.Writer, v Version, src, dst *net.TCPAddr) error {
+	var b []byte
+	switch v {
+	case None:
+		return nil
+	case V1:
+		b = headerV1(src, dst)
+	case V2:
+		b = headerV2(src, dst)
+	default:
+		return fmt.Errorf("unsupported PROXY protocol version %d", v)
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func headerV1(src, dst *net.TCPAddr) []byte {
+	if !known(src, dst) {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	proto, sip, dip := "TCP4", src.IP.To4(), dst.IP.To4()
+	if sip == nil || dip == nil {
+		proto, sip, dip = "TCP6", src.IP.To16(), dst.IP.To16()
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, sip, dip, src.Port, dst.Port))
+}
+
+func headerV2(src, dst *net.TCPAddr) []byte {
+	var b bytes.Buffer
+	b.Write(signature)
+
+	if !known(src, dst) {
+		// version 2, LOCAL command, unspecified family.
+		b.Write([]byte{0x20, 0x00, 0x00, 0x00})
+		return b.Bytes()
+	}
+
+	// version 2, PROXY command.
+	b.WriteByte(0x21)
+	sip, dip := src.IP.To4(), dst.IP.To4()
+	if sip != nil && dip != nil {
+		b.WriteByte(0x11) // TCP over IPv4
+		binary.Write(&b, binary.BigEndian, uint16(12))
+	} else {
+		sip, dip = src.IP.To16(), dst.IP.To16()
+		b.WriteByte(0x21) // TCP over IPv6
+		binary.Write(&b, binary.BigEndian, uint16(36))
+	}
+	b.Write(sip)
+	b.Write(dip)
+	binary.Write(&b, binary.BigEndian, uint16(src.Port))
+	binary.Write(&b, binary.BigEndian, uint16(dst.Port))
+	return b.Bytes()
+}
+
+func known(src, dst *net.TCPAddr) bool {
+	return src != nil && dst != nil && src.IP != nil && dst.IP != nil
+}
@@ -0,0 +1,152 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func tcpAddr(s string) *net.TCPAddr {
+	a, err := net.ResolveTCPAddr("tcp", s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func TestRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name     string
+		src, dst *net.TCPAddr
+	}{
+		{"ipv4", tcpAddr("192.0.2.1:51234"), tcpAddr("198.51.100.7:443")},
+		{"ipv6", tcpAddr("[2001:db8::1]:51234"), tcpAddr("[2001:db8::2]:443")},
+		{"mixed", tcpAddr("192.0.2.1:51234"), tcpAddr("[2001:db8::2]:443")},
+		{"unknown", nil, nil},
+	}
+	payload := []byte("GET / HTTP/1.1\r\n\r\n")
+	for _, v := range []Version{V1, V2} {
+		for _, tc := range testCases {
+			t.Run(tc.name+"/"+map[Version]string{V1: "v1", V2: "v2"}[v], func(t *testing.T) {
+				var b bytes.Buffer
+				if err := WriteHeader(&b, v, tc.src, tc.dst); err != nil {
+					t.Fatal(err)
+				}
+				b.Write(payload)
+
+				r := bufio.NewReader(&b)
+				src, dst, err := ReadHeader(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !sameAddr(src, tc.src) || !sameAddr(dst, tc.dst) {
+					t.Errorf("got %v -> %v, want %v -> %v", src, dst, tc.src, tc.dst)
+				}
+				rest, _ := io.ReadAll(r)
+				if !bytes.Equal(rest, payload) {
+					t.Errorf("got %q after the header, want %q", rest, payload)
+				}
+			})
+		}
+	}
+}
+
+// sameAddr compares addresses, ignoring the IPv4-in-IPv6 representation.
+func sameAddr(a, b *net.TCPAddr) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+func TestWriteHeader(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteHeader(&b, V1, tcpAddr("192.0.2.1:51234"), tcpAddr("198.51.100.7:443")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "PROXY TCP4 192.0.2.1 198.51.100.7 51234 443\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	b.Reset()
+	if err := WriteHeader(&b, V2, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.Bytes(), append(append([]byte(nil), signature...), 0x20, 0x00, 0x00, 0x00); !bytes.Equal(got, want) {
+		t.Errorf("got LOCAL header %x, want %x", got, want)
+	}
+
+	b.Reset()
+	if err := WriteHeader(&b, None, tcpAddr("192.0.2.1:1"), tcpAddr("192.0.2.2:2")); err != nil || b.Len() != 0 {
+		t.Errorf("got %q, %v without PROXY protocol, want nothing", b.Bytes(), err)
+	}
+}
+
+// v2Header builds a v2 header with a given version/command, family and address block.
+func v2Header(verCmd, family byte, length int, body []byte) []byte {
+	b := append([]byte(nil), signature...)
+	b = append(b, verCmd, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(length))
+	return append(b, body...)
+}
+
+func TestReadHeaderV2(t *testing.T) {
+	// TLVs after the addresses are skipped.
+	body := append(net.ParseIP("192.0.2.1").To4(), net.ParseIP("198.51.100.7").To4()...)
+	body = binary.BigEndian.AppendUint16(body, 51234)
+	body = binary.BigEndian.AppendUint16(body, 443)
+	body = append(body, 0x04, 0x00, 0x02, 'h', 'i')
+	r := bufio.NewReader(bytes.NewReader(append(v2Header(0x21, 0x11, len(body), body), "data"...)))
+	src, dst, err := ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.String() != "192.0.2.1:51234" || dst.String() != "198.51.100.7:443" {
+		t.Errorf("got %v -> %v", src, dst)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "data" {
+		t.Errorf("got %q after the header", rest)
+	}
+
+	// a LOCAL header with an address block carries no address.
+	r = bufio.NewReader(bytes.NewReader(v2Header(0x20, 0x11, len(body), body)))
+	if src, dst, err := ReadHeader(r); err != nil || src != nil || dst != nil {
+		t.Errorf("got %v -> %v, %v for a LOCAL header", src, dst, err)
+	}
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	ipv4Body := make([]byte, 12)
+	testCases := []struct {
+		name  string
+		input []byte
+	}{
+		{"empty", nil},
+		{"no header", []byte("GET / HTTP/1.1\r\n\r\n")},
+		{"bad signature", append([]byte("\r\n\r\n\x00\r\nQUIX\n"), 0x21, 0x11, 0x00, 0x0c)},
+		{"v1 truncated", []byte("PROXY TCP4 192.0.2.1 198.51.100.7 51234")},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n")},
+		{"v1 bad protocol", []byte("PROXY UDP4 192.0.2.1 198.51.100.7 51234 443\r\n")},
+		{"v1 missing field", []byte("PROXY TCP4 192.0.2.1 198.51.100.7 51234\r\n")},
+		{"v1 bad address", []byte("PROXY TCP4 192.0.2.300 198.51.100.7 51234 443\r\n")},
+		{"v1 bad port", []byte("PROXY TCP4 192.0.2.1 198.51.100.7 51234 70000\r\n")},
+		{"v2 truncated header", v2Header(0x21, 0x11, 12, nil)[:14]},
+		{"v2 truncated body", v2Header(0x21, 0x11, 12, ipv4Body[:6])},
+		{"v2 oversized length", v2Header(0x21, 0x11, 0xffff, ipv4Body)},
+		{"v2 short address block", v2Header(0x21, 0x11, 6, ipv4Body[:6])},
+		{"v2 bad version", v2Header(0x11, 0x11, 12, ipv4Body)},
+		{"v2 bad command", v2Header(0x22, 0x11, 12, ipv4Body)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src, dst, err := ReadHeader(bufio.NewReader(bytes.NewReader(tc.input)))
+			if err == nil {
+				t.Errorf("expected an error, got %v -> %v", src, dst)
+			}
+		})
+	}
+}
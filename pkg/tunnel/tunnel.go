@@ -16,20 +16,48 @@ const (
 )
 
 // HeaderFor returns a header for a given tunnel ID and connection.
-func HeaderFor(tunnelID string, conn net.Conn) *tunnelpb.Up_Header {
-	return &tunnelpb.Up_Header{
+// The serverName is the SNI name sent by the client.
+func HeaderFor(tunnelID, serverName string, conn net.Conn) *tunnelpb.Up_Header {
+	hdr := &tunnelpb.Up_Header{
 		TunnelId: tunnelID,
-		// TODO(mkm): other stuff
+		Protocol: "TCP",
+		Sni:      serverName,
 	}
+	if a, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		hdr.Saddr = a.IP.String()
+		hdr.Sport = int32(a.Port)
+	}
+	if a, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		hdr.Daddr = a.IP.String()
+		hdr.Dport = int32(a.Port)
+	}
+	return hdr
+}
+
+// SourceAddr returns the address of the client that originated the tunneled connection.
+func SourceAddr(hdr *tunnelpb.Up_Header) *net.TCPAddr {
+	return &net.TCPAddr{IP: net.ParseIP(hdr.GetSaddr()), Port: int(hdr.GetSport())}
+}
+
+// DestinationAddr returns the ingress address the client connected to.
+func DestinationAddr(hdr *tunnelpb.Up_Header) *net.TCPAddr {
+	return &net.TCPAddr{IP: net.ParseIP(hdr.GetDaddr()), Port: int(hdr.GetDport())}
 }
 
 // Siphon connects a network connection with a Tunnel gRPC service
 // and copies data bidirectionally.
-// If the header is not nil it will be sent in the first up frame.
-func Siphon(ctx context.Context, tunnel tunnelpb.TunnelClient, header *tunnelpb.Up_Header, conn net.Conn) error {
+// If the header is not nil it will be sent right away in the first up frame,
+// so that the egress can connect to its target before the client sends any data.
+// The returned channel is closed when the stream is over.
+func Siphon(ctx context.Context, tunnel tunnelpb.TunnelClient, header *tunnelpb.Up_Header, conn net.Conn) (<-chan struct{}, error) {
 	s, err := tunnel.NewStream(ctx)
 	if err != nil {
-		return fmt.Errorf("error siphoning: %w", err)
+		return nil, fmt.Errorf("error siphoning: %w", err)
+	}
+	if header != nil {
+		if err := s.Send(&tunnelpb.Up{Header: header}); err != nil {
+			return nil, fmt.Errorf("error sending header: %w", err)
+		}
 	}
 
 	go func() {
@@ -45,7 +73,6 @@ func Siphon(ctx context.Context, tunnel tunnelpb.TunnelClient, header *tunnelpb.
 
 			glog.Infof("sending %d bytes up, eof? %v", n, err == io.EOF)
 			s.Send(&tunnelpb.Up{
-				Header: header,
 				Data:   data[:n],
 				Finish: finish, // TODO(mkm): figure out if we really need this in this direction.
 			})
@@ -53,13 +80,14 @@ func Siphon(ctx context.Context, tunnel tunnelpb.TunnelClient, header *tunnelpb.
 				s.CloseSend()
 				break
 			}
-			header = nil
 		}
 
 		glog.Infof("done with up siphoning")
 	}()
 
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		for {
 			down, err := s.Recv()
 			if err == io.EOF {
@@ -75,5 +103,5 @@ func Siphon(ctx context.Context, tunnel tunnelpb.TunnelClient, header *tunnelpb.
 		glog.Infof("done with down siphoning")
 	}()
 
-	return nil
+	return done, nil
 }
@@ -0,0 +1,132 @@
+package uplink
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/mkmik/udig/pkg/tunnel/tunnelpb"
+)
+
+const (
+	// DefaultBalancer is the name of the load balancing strategy used unless configured otherwise.
+	DefaultBalancer = "round-robin"
+)
+
+// A Candidate is an uplink that can serve a new stream.
+type Candidate struct {
+	UplinkID string
+	// ActiveStreams is the number of streams currently tunneled through the uplink.
+	ActiveStreams int64
+}
+
+// A Balancer picks the uplink serving a new stream among the uplinks of a tunnel.
+type Balancer interface {
+	// Pick returns the index of the chosen candidate. The candidates are never empty and
+	// are sorted by uplink ID; hdr describes the client session.
+	Pick(tunnelID string, candidates []Candidate, hdr *tunnelpb.Up_Header) int
+}
+
+// BalancerNames lists the names accepted by NewBalancer.
+var BalancerNames = []string{"round-robin", "least-active", "two-choices", "source-hash", "random"}
+
+// NewBalancer creates a load balancing strategy by name:
+//
+//	round-robin:  cycles through the uplinks of each tunnel
+//	least-active: picks the uplink with the fewest active streams
+//	two-choices:  picks the uplink with fewer active streams among two random ones
+//	source-hash:  sticks each client IP address to an uplink, moving as few clients as possible when uplinks change
+//	random:       picks a random uplink
+func NewBalancer(name string) (Balancer, error) {
+	switch name {
+	case "round-robin":
+		return &roundRobin{next: map[string]uint64{}}, nil
+	case "least-active":
+		return leastActive{}, nil
+	case "two-choices":
+		return twoChoices{}, nil
+	case "source-hash":
+		return sourceHash{}, nil
+	case "random":
+		return random{}, nil
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy %q (valid: %q)", name, BalancerNames)
+	}
+}
+
+type roundRobin struct {
+	mu   sync.Mutex
+	next map[string]uint64 // by tunnel ID
+}
+
+func (b *roundRobin) Pick(tunnelID string, candidates []Candidate, _ *tunnelpb.Up_Header) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.next[tunnelID]
+	b.next[tunnelID] = n + 1
+	return int(n % uint64(len(candidates)))
+}
+
+// Forget drops the state of a tunnel that has no uplinks left.
+func (b *roundRobin) Forget(tunnelID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.next, tunnelID)
+}
+
+type leastActive struct{}
+
+func (leastActive) Pick(_ string, candidates []Candidate, _ *tunnelpb.Up_Header) int {
+	best := 0
+	for i, c := range candidates {
+		if c.ActiveStreams < candidates[best].ActiveStreams {
+			best = i
+		}
+	}
+	return best
+}
+
+type twoChoices struct{}
+
+func (twoChoices) Pick(_ string, candidates []Candidate, _ *tunnelpb.Up_Header) int {
+	if len(candidates) == 1 {
+		return 0
+	}
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	if candidates[j].ActiveStreams < candidates[i].ActiveStreams {
+		return j
+	}
+	return i
+}
+
+type sourceHash struct{}
+
+// Pick uses rendezvous hashing of the client IP address and the uplink IDs.
+func (sourceHash) Pick(_ string, candidates []Candidate, hdr *tunnelpb.Up_Header) int {
+	best, bestWeight := 0, uint64(0)
+	for i, c := range candidates {
+		h := sha256.Sum256([]byte(hdr.GetSaddr() + "\x00" + c.UplinkID))
+		if w := binary.BigEndian.Uint64(h[:8]); i == 0 || w > bestWeight {
+			best, bestWeight = i, w
+		}
+	}
+	return best
+}
+
+type random struct{}
+
+func (random) Pick(_ string, candidates []Candidate, _ *tunnelpb.Up_Header) int {
+	return rand.Intn(len(candidates))
+}
+
+// sortCandidates sorts candidates by uplink ID, as expected by Balancer.Pick.
+func sortCandidates(candidates []Candidate) {
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].UplinkID < candidates[j].UplinkID })
+}
@@ -0,0 +1,118 @@
+package uplink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	eventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "udig_router_events_dropped_total",
+		Help: "Number of events not delivered to a subscriber that fell behind, by event type.",
+	}, []string{"type"})
+)
+
+func init() {
+	prometheus.MustRegister(eventsDropped)
+}
+
+// An EventType identifies a tunnel lifecycle event.
+type EventType string
+
+const (
+	// TunnelRegistered is emitted when the first uplink of a tunnel registers.
+	TunnelRegistered EventType = "tunnel-registered"
+	// TunnelRemoved is emitted when the last uplink of a tunnel goes away.
+	TunnelRemoved EventType = "tunnel-removed"
+	// UplinkAdded is emitted whenever an uplink registers.
+	UplinkAdded EventType = "uplink-added"
+	// StreamOpened is emitted when a client stream is tunneled through an uplink.
+	StreamOpened EventType = "stream-opened"
+)
+
+// EventTypes lists all the event types.
+var EventTypes = []EventType{TunnelRegistered, TunnelRemoved, UplinkAdded, StreamOpened}
+
+// ParseEventType returns the event type with a given name.
+func ParseEventType(name string) (EventType, error) {
+	for _, t := range EventTypes {
+		if string(t) == name {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event type %q (valid: %q)", name, EventTypes)
+}
+
+// An Event is a tunnel lifecycle event emitted by the router.
+type Event struct {
+	Type     EventType `json:"type"`
+	Time     time.Time `json:"time"`
+	TunnelID string    `json:"tunnelId"`
+	// UplinkID is set for uplink and stream events.
+	UplinkID string `json:"uplinkId,omitempty"`
+	// RemoteAddr is the address of the uplink connection for uplink events, and of the client for stream events.
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// Ingress lists the ingress addresses of the tunnel, for tunnel-registered and uplink-added events.
+	Ingress []string `json:"ingress,omitempty"`
+}
+
+// eventHub fans out events to subscribers.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan Event]map[EventType]bool // event types wanted by each subscriber; nil: all
+}
+
+// publish sends an event to the subscribers interested in it, without waiting for slow ones.
+func (h *eventHub) publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c, types := range h.subs {
+		if types != nil && !types[e.Type] {
+			continue
+		}
+		select {
+		case c <- e:
+		default:
+			eventsDropped.WithLabelValues(string(e.Type)).Inc()
+			glog.Warningf("dropping %s event for tunnel %q: subscriber is too slow", e.Type, e.TunnelID)
+		}
+	}
+}
+
+func (h *eventHub) subscribe(size int, types []EventType) (<-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = map[chan Event]map[EventType]bool{}
+	}
+	var wanted map[EventType]bool
+	if len(types) > 0 {
+		wanted = map[EventType]bool{}
+		for _, t := range types {
+			wanted[t] = true
+		}
+	}
+	c := make(chan Event, size)
+	h.subs[c] = wanted
+
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			delete(h.subs, c)
+			close(c)
+		})
+	}
+}
+
+// Subscribe returns a channel receiving the tunnel lifecycle events of the given types (all if none),
+// which buffers up to size events; events are dropped when the subscriber falls further behind.
+// The returned function cancels the subscription and closes the channel.
+func (r *InProcessRouter) Subscribe(size int, types ...EventType) (<-chan Event, func()) {
+	return r.events.subscribe(size, types)
+}
@@ -0,0 +1,60 @@
+package uplink
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSubscribe(t *testing.T) {
+	var h eventHub
+	all, cancelAll := h.subscribe(2, nil)
+	defer cancelAll()
+	tunnels, cancelTunnels := h.subscribe(2, []EventType{TunnelRegistered, TunnelRemoved})
+	defer cancelTunnels()
+
+	dropped := func(typ EventType) float64 { return testutil.ToFloat64(eventsDropped.WithLabelValues(string(typ))) }
+	droppedStreams, droppedRemovals := dropped(StreamOpened), dropped(TunnelRemoved)
+
+	for _, typ := range []EventType{TunnelRegistered, StreamOpened, StreamOpened, TunnelRemoved} {
+		h.publish(Event{Type: typ, TunnelID: "t"})
+	}
+
+	receive := func(c <-chan Event) []EventType {
+		var res []EventType
+		for {
+			select {
+			case e := <-c:
+				res = append(res, e.Type)
+			default:
+				return res
+			}
+		}
+	}
+	// the subscriber of all the events falls behind.
+	if got, want := receive(all), []EventType{TunnelRegistered, StreamOpened}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := dropped(StreamOpened) - droppedStreams; got != 1 {
+		t.Errorf("got %v dropped %s events, want 1", got, StreamOpened)
+	}
+	if got := dropped(TunnelRemoved) - droppedRemovals; got != 1 {
+		t.Errorf("got %v dropped %s events, want 1", got, TunnelRemoved)
+	}
+	// the stream-opened events don't take room in the buffer of a subscriber not interested in them.
+	if got, want := receive(tunnels), []EventType{TunnelRegistered, TunnelRemoved}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseEventType(t *testing.T) {
+	for _, typ := range EventTypes {
+		if got, err := ParseEventType(string(typ)); err != nil || got != typ {
+			t.Errorf("ParseEventType(%q) = %q, %v", typ, got, err)
+		}
+	}
+	if _, err := ParseEventType("stream-closed"); err == nil {
+		t.Error("expected an error for an unknown event type")
+	}
+}
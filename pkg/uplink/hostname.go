@@ -0,0 +1,63 @@
+package uplink
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	// hostnameProofPrefix prefixes the signature in the TXT record value.
+	hostnameProofPrefix = "udig-signature="
+)
+
+// A TXTResolver looks up DNS TXT records; net.Resolver implements it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// HostnameProofName returns the name of the TXT record that proves the ownership of a custom host name.
+func HostnameProofName(hostname string) string {
+	return "_udig." + canonicalHostname(hostname)
+}
+
+// HostnameProof returns the value of the TXT record that proves that the owner of a tunnel key
+// claims a custom host name.
+func HostnameProof(priv ed25519.PrivateKey, hostname string) string {
+	sig := ed25519.Sign(priv, hostnameProofMessage(hostname))
+	return hostnameProofPrefix + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// VerifyHostname checks that the TXT records of a custom host name contain a valid proof for a tunnel key.
+func VerifyHostname(ctx context.Context, resolver TXTResolver, pub ed25519.PublicKey, hostname string) error {
+	name := HostnameProofName(hostname)
+	txts, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return fmt.Errorf("looking up %q: %w", name, err)
+	}
+	msg := hostnameProofMessage(hostname)
+	for _, txt := range txts {
+		if !strings.HasPrefix(txt, hostnameProofPrefix) {
+			continue
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(txt, hostnameProofPrefix))
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(pub, msg, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no valid proof for the tunnel key found in %q", name)
+}
+
+func hostnameProofMessage(hostname string) []byte {
+	return []byte("udig hostname " + canonicalHostname(hostname))
+}
+
+func canonicalHostname(hostname string) string {
+	return strings.ToLower(strings.TrimSuffix(hostname, "."))
+}
@@ -0,0 +1,53 @@
+package uplink
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	// pinPrefix prefixes public key pins; it's the same syntax as curl's --pinnedpubkey.
+	pinPrefix = "sha256//"
+)
+
+// PublicKeyPin returns the pin of the public key of a certificate, i.e. "sha256//" followed
+// by the base64 encoded SHA-256 digest of its DER encoded SubjectPublicKeyInfo.
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ValidatePin checks the syntax of a public key pin.
+func ValidatePin(pin string) error {
+	if !strings.HasPrefix(pin, pinPrefix) {
+		return fmt.Errorf("bad public key pin %q: must start with %q", pin, pinPrefix)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+	if err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("bad public key pin %q: not a base64 encoded SHA-256 digest", pin)
+	}
+	return nil
+}
+
+// VerifyPin returns a function, meant to be used as tls.Config.VerifyPeerCertificate, that accepts
+// a certificate chain only if the public key of its leaf certificate matches the pin.
+// Only the leaf counts, because its key is the one that signs the handshake: the other certificates
+// of the chain are not verified, so a peer could send any of them, e.g. the public broker certificate.
+func VerifyPin(pin string) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("broker sent no certificate")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		if got := PublicKeyPin(cert); got != pin {
+			return fmt.Errorf("broker public key pin mismatch: got %s, want %s", got, pin)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package uplink
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// selfSigned returns a self-signed certificate for name and its key.
+func selfSigned(t *testing.T, name string) ([]byte, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, key
+}
+
+func pinOf(t *testing.T, der []byte) string {
+	t.Helper()
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return PublicKeyPin(cert)
+}
+
+func TestVerifyPin(t *testing.T) {
+	broker, _ := selfSigned(t, "uplink.udig.test")
+	attacker, _ := selfSigned(t, "attacker.test")
+	pin := pinOf(t, broker)
+	if err := ValidatePin(pin); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		chain   [][]byte
+		wantErr bool
+	}{
+		{name: "broker leaf", chain: [][]byte{broker}},
+		{name: "broker leaf with extra certificates", chain: [][]byte{broker, attacker}},
+		{name: "other leaf", chain: [][]byte{attacker}, wantErr: true},
+		{name: "broker certificate after another leaf", chain: [][]byte{attacker, broker}, wantErr: true},
+		{name: "empty chain", chain: nil, wantErr: true},
+		{name: "garbage", chain: [][]byte{[]byte("not a certificate")}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifyPin(pin)(tc.chain, nil)
+			if tc.wantErr && err == nil {
+				t.Error("expected the chain to be rejected")
+			} else if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestVerifyPinHandshake checks that a server holding only the public broker certificate
+// can't pass the pin check by appending it to its own chain.
+func TestVerifyPinHandshake(t *testing.T) {
+	broker, brokerKey := selfSigned(t, "uplink.udig.test")
+	attacker, attackerKey := selfSigned(t, "attacker.test")
+	pin := pinOf(t, broker)
+
+	handshake := func(cert tls.Certificate) error {
+		c, s := net.Pipe()
+		defer c.Close()
+		defer s.Close()
+		go tls.Server(s, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+		return tls.Client(c, &tls.Config{
+			ServerName:            "uplink.udig.test",
+			InsecureSkipVerify:    true,
+			VerifyPeerCertificate: VerifyPin(pin),
+		}).Handshake()
+	}
+
+	if err := handshake(tls.Certificate{Certificate: [][]byte{broker}, PrivateKey: brokerKey}); err != nil {
+		t.Errorf("handshake with the pinned broker failed: %v", err)
+	}
+	if err := handshake(tls.Certificate{Certificate: [][]byte{attacker, broker}, PrivateKey: attackerKey}); err == nil {
+		t.Error("handshake with the attacker chain succeeded")
+	}
+}
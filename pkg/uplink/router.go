@@ -2,18 +2,54 @@ package uplink
 
 import (
 	"context"
+	"fmt"
+	"hash/fnv"
 	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/mkmik/udig/pkg/tunnel"
 	"github.com/mkmik/udig/pkg/tunnel/tunnelpb"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	// unhealthyCooldown is how long an uplink that failed to open a stream is avoided,
+	// as long as the tunnel has other uplinks.
+	unhealthyCooldown = 30 * time.Second
+
+	// maxPendingStreams bounds the number of streams held per tunnel during the grace window.
+	maxPendingStreams = 64
+
+	// routerShards is the number of independently locked partitions of the tunnel table.
+	routerShards = 64
+)
+
+var (
+	failoversTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "udig_router_failovers_total",
+		Help: "Number of times a stream was retried on another uplink after failing to open on one.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(failoversTotal)
+}
+
 // A NewStream struct encapsulates an intent to tunnel a new connection
 // for a given tunnel ID on any uplink that can fullfull that request.
 type NewStream struct {
+	// TunnelID, if empty, is resolved by the router from the host name in Header.Sni.
 	TunnelID string
 	Conn     net.Conn
+	// Header describes the original client session; it's relayed to the uplink.
+	Header *tunnelpb.Up_Header
+	// Passthrough is true if Conn carries the raw client TLS stream.
+	Passthrough bool
 }
 
 // A Router receives NewStream requests and forwards them to the appropriate uplink.
@@ -28,24 +64,119 @@ type Change struct {
 	TunnelID string
 	UplinkID string                // something unique about the uplink connection
 	Client   tunnelpb.TunnelClient // if nil, uplink instance removed
+	// Passthrough is true if the uplink wants the raw client TLS stream.
+	Passthrough bool
+	// Hostnames lists the verified custom host names routed to the tunnel.
+	Hostnames []string
+	// Balancer is the name of the load balancing strategy requested for the tunnel (see NewBalancer);
+	// if empty, the router default is used.
+	Balancer string
+	// RemoteAddr is the address the uplink connection comes from.
+	RemoteAddr string
+	// Close, if not nil, forcibly disconnects the uplink.
+	Close func()
+	// Ingress lists the ingress addresses advertised to the uplink; it's reported in events.
+	Ingress []string
+	// Ports lists the ingress ports whose streams the uplink may receive; if nil, streams on any port are routed to it.
+	Ports []int32
+}
+
+// uplinkClient is an uplink instance registered in the router.
+type uplinkClient struct {
+	client      tunnelpb.TunnelClient
+	passthrough bool
+	hostnames   []string
+	balancer    string
+	remoteAddr  string
+	registered  time.Time
+	close       func()
+	ports       map[int32]bool // nil: any port
+
+	// accessed atomically
+	active         int64 // active streams
+	unhealthyUntil int64 // UnixNano; zero if healthy
+	bytesUp        int64 // received from clients
+	bytesDown      int64 // sent to clients
+}
+
+// countingConn counts the bytes tunneled through an uplink.
+type countingConn struct {
+	net.Conn
+	up *uplinkClient
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.up.bytesUp, int64(n))
+	return n, err
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.up.bytesDown, int64(n))
+	return n, err
+}
+
+// tunnelEntry is the routing state of a tunnel.
+type tunnelEntry struct {
+	ups       map[string]*uplinkClient // by uplink ID
+	hostnames []string                 // custom host names routed to the tunnel
+
+	// lost is when the last uplink went away, if the tunnel is in its grace window.
+	lost time.Time
+	// pending are the streams held during the grace window.
+	pending []NewStream
+}
+
+// routerShard is a partition of the tunnel table.
+type routerShard struct {
+	mu      sync.Mutex
+	tunnels map[string]*tunnelEntry
 }
 
 // InProcessRouter connects uplinks and ingresses in the same process.
+//
+// The tunnel table is split in shards with their own locks, and each new stream is set up by its own goroutine,
+// so that a slow uplink only delays the streams of its tunnel.
 type InProcessRouter struct {
 	ingress chan NewStream
 	uplink  chan Change
-	m       map[string]map[string]tunnelpb.TunnelClient
+	domain  string
+
+	// Balancer spreads the streams of a tunnel among its uplinks, unless the tunnel requested another strategy.
+	Balancer Balancer
+	// GraceWindow is how long new streams for a tunnel that just lost its last uplink are held,
+	// waiting for an uplink to register again, before being closed. Zero disables holding.
+	GraceWindow time.Duration
+
+	shards [routerShards]routerShard
+
+	hostsMu sync.RWMutex
+	hosts   map[string]string // custom host name -> tunnel ID
+
+	balancersMu sync.Mutex
+	balancers   map[string]Balancer // strategies requested by tunnels, by name
+
+	events eventHub
 }
 
-// NewInProcessRouter creates an InProcessRouter.
-func NewInProcessRouter() *InProcessRouter {
+// NewInProcessRouter creates an InProcessRouter for tunnels exposed under domain.
+func NewInProcessRouter(domain string) *InProcessRouter {
 	r := &InProcessRouter{
 		ingress: make(chan NewStream),
 		uplink:  make(chan Change),
-		m:       map[string]map[string]tunnelpb.TunnelClient{},
+		domain:  canonicalHostname(domain),
+		hosts:   map[string]string{},
+
+		balancers: map[string]Balancer{},
+	}
+	for i := range r.shards {
+		r.shards[i].tunnels = map[string]*tunnelEntry{}
 	}
+	r.Balancer, _ = NewBalancer(DefaultBalancer)
 
-	go r.run()
+	go r.runIngress()
+	go r.runUplink()
 	return r
 }
 
@@ -55,43 +186,414 @@ func (r *InProcessRouter) Ingress() chan<- NewStream { return r.ingress }
 // Uplink returns a channel of uplink changes.
 func (r *InProcessRouter) Uplink() chan<- Change { return r.uplink }
 
-func (r *InProcessRouter) run() {
+// shard returns the shard holding a tunnel.
+func (r *InProcessRouter) shard(tunnelID string) *routerShard {
+	h := fnv.New32a()
+	h.Write([]byte(tunnelID))
+	return &r.shards[h.Sum32()%routerShards]
+}
+
+// HasTunnel returns true if there is at least one active uplink for a tunnel ID.
+func (r *InProcessRouter) HasTunnel(tunnelID string) bool {
+	s := r.shard(tunnelID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tunnels[tunnelID]
+	return t != nil && len(t.ups) > 0
+}
+
+// HasHostname returns true if a custom host name is routed to a tunnel with at least one active uplink.
+func (r *InProcessRouter) HasHostname(hostname string) bool {
+	r.hostsMu.RLock()
+	defer r.hostsMu.RUnlock()
+	_, ok := r.hosts[canonicalHostname(hostname)]
+	return ok
+}
+
+// updateHostnames replaces the custom host names routed to a tunnel.
+func (r *InProcessRouter) updateHostnames(tunnelID string, old, hostnames []string) {
+	r.hostsMu.Lock()
+	defer r.hostsMu.Unlock()
+	for _, h := range old {
+		if r.hosts[h] == tunnelID {
+			delete(r.hosts, h)
+		}
+	}
+	for _, h := range hostnames {
+		r.hosts[h] = tunnelID
+	}
+}
+
+// resolve sets the tunnel ID of a stream from the host name the client connected to, which is
+// either a verified custom host name or [<label>.]<tunnel_id>.<domain>.
+func (r *InProcessRouter) resolve(in *NewStream) {
+	if in.TunnelID != "" {
+		return
+	}
+	host := canonicalHostname(in.Header.GetSni())
+
+	r.hostsMu.RLock()
+	tid, ok := r.hosts[host]
+	r.hostsMu.RUnlock()
+	if !ok {
+		tid = TunnelIDFromHost(host, r.domain)
+	}
+
+	in.TunnelID = tid
+	if in.Header != nil {
+		in.Header.TunnelId = tid
+	}
+}
+
+// TunnelIDFromHost extracts the tunnel ID from a [<label>.]<tunnel_id>.<domain> host name.
+func TunnelIDFromHost(host, domain string) string {
+	labels := strings.Split(strings.TrimSuffix(host, "."+domain), ".")
+	return labels[len(labels)-1]
+}
+
+// pick chooses the uplink serving a new stream, or returns an empty ID if the tunnel has no suitable uplink.
+// Uplinks in tried are skipped, and unhealthy uplinks are used only if no healthy one is left.
+// If the tunnel is in its grace window, the stream is held and pick returns true.
+func (r *InProcessRouter) pick(in *NewStream, tried map[string]bool) (string, *uplinkClient, bool) {
+	s := r.shard(in.TunnelID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.tunnels[in.TunnelID]
+	if t == nil {
+		return "", nil, false
+	}
+	if len(t.ups) == 0 {
+		if t.lost.IsZero() || len(t.pending) >= maxPendingStreams {
+			return "", nil, false
+		}
+		glog.Infof("holding stream for tunnel %q until an uplink registers", in.TunnelID)
+		t.pending = append(t.pending, *in)
+		return "", nil, true
+	}
+
+	var (
+		candidates, unhealthy []Candidate
+		balancer              string
+	)
+	now := time.Now().UnixNano()
+	for id, up := range t.ups {
+		// never hand the raw TLS stream to an uplink expecting plaintext and
+		// never decrypt traffic meant to be passed through.
+		if up.passthrough != in.Passthrough || tried[id] {
+			continue
+		}
+		// only route streams arriving on the ports the uplink was granted.
+		if up.ports != nil && !up.ports[in.Header.GetDport()] {
+			continue
+		}
+		c := Candidate{UplinkID: id, ActiveStreams: atomic.LoadInt64(&up.active)}
+		if now < atomic.LoadInt64(&up.unhealthyUntil) {
+			unhealthy = append(unhealthy, c)
+		} else {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = unhealthy
+	}
+	if len(candidates) == 0 {
+		return "", nil, false
+	}
+	sortCandidates(candidates)
+	for _, c := range candidates {
+		if b := t.ups[c.UplinkID].balancer; b != "" {
+			balancer = b
+			break
+		}
+	}
+
+	id := candidates[r.balancer(balancer).Pick(in.TunnelID, candidates, in.Header)].UplinkID
+	return id, t.ups[id], false
+}
+
+// dispatch tunnels a new stream through one of the uplinks of its tunnel. If an uplink fails to open the stream,
+// it's marked unhealthy and the next one is tried; the connection is closed only when all uplinks failed.
+func (r *InProcessRouter) dispatch(in NewStream) {
+	tried := map[string]bool{}
 	for {
-		select {
-		case up := <-r.uplink:
-			glog.Infof("got uplink change request: %v", up)
-			if _, ok := r.m[up.TunnelID]; !ok {
-				r.m[up.TunnelID] = map[string]tunnelpb.TunnelClient{}
-			}
-			ups := r.m[up.TunnelID]
-			if up.Client != nil {
-				ups[up.UplinkID] = up.Client
-			} else {
-				delete(ups, up.UplinkID)
-			}
-			if len(ups) == 0 {
-				delete(r.m, up.TunnelID)
+		id, up, held := r.pick(&in, tried)
+		if held {
+			return
+		}
+		if up == nil {
+			break
+		}
+		if len(tried) > 0 {
+			failoversTotal.Inc()
+		}
+		tried[id] = true
+
+		// each attempt has its own context, so that a stream that failed after being opened is released.
+		ctx, cancel := context.WithCancel(context.Background())
+		atomic.AddInt64(&up.active, 1)
+		done, err := tunnel.Siphon(ctx, up.client, in.Header, countingConn{in.Conn, up})
+		if err != nil {
+			cancel()
+			glog.Errorf("tunnel %q: uplink %q: %v", in.TunnelID, id, err)
+			atomic.AddInt64(&up.active, -1)
+			atomic.StoreInt64(&up.unhealthyUntil, time.Now().Add(unhealthyCooldown).UnixNano())
+			continue
+		}
+		atomic.StoreInt64(&up.unhealthyUntil, 0)
+		r.events.publish(Event{
+			Type:       StreamOpened,
+			Time:       time.Now(),
+			TunnelID:   in.TunnelID,
+			UplinkID:   id,
+			RemoteAddr: tunnel.SourceAddr(in.Header).String(),
+		})
+		go func() {
+			<-done
+			cancel()
+			atomic.AddInt64(&up.active, -1)
+		}()
+		return
+	}
+
+	if len(tried) == 0 {
+		glog.Errorf("cannot find any uplink for tunnel %q", in.TunnelID)
+	} else {
+		glog.Errorf("all the %d uplinks of tunnel %q failed", len(tried), in.TunnelID)
+	}
+	in.Conn.Close()
+}
+
+// balancer returns the load balancing strategy with a given name, or the default one if the name is empty.
+func (r *InProcessRouter) balancer(name string) Balancer {
+	if name == "" {
+		return r.Balancer
+	}
+	r.balancersMu.Lock()
+	defer r.balancersMu.Unlock()
+	b, ok := r.balancers[name]
+	if !ok {
+		var err error
+		if b, err = NewBalancer(name); err != nil {
+			glog.Errorf("%v; using the default", err)
+			b = r.Balancer
+		}
+		r.balancers[name] = b
+	}
+	return b
+}
+
+// forget drops the balancer state of a tunnel that has no uplinks left.
+func (r *InProcessRouter) forget(tunnelID string) {
+	type forgetter interface {
+		Forget(tunnelID string)
+	}
+	r.balancersMu.Lock()
+	defer r.balancersMu.Unlock()
+	bs := []Balancer{r.Balancer}
+	for _, b := range r.balancers {
+		bs = append(bs, b)
+	}
+	for _, b := range bs {
+		if f, ok := b.(forgetter); ok {
+			f.Forget(tunnelID)
+		}
+	}
+}
+
+// change applies an uplink change. When a tunnel loses its last uplink, its grace window starts;
+// when an uplink registers, the streams held meanwhile are dispatched.
+func (r *InProcessRouter) change(up Change) {
+	glog.Infof("got uplink change request: %v", up)
+	s := r.shard(up.TunnelID)
+	s.mu.Lock()
+
+	t := s.tunnels[up.TunnelID]
+	if t == nil {
+		t = &tunnelEntry{ups: map[string]*uplinkClient{}}
+		s.tunnels[up.TunnelID] = t
+	}
+	var events []Event
+	now := time.Now()
+	if _, ok := t.ups[up.UplinkID]; up.Client != nil && !ok {
+		if len(t.ups) == 0 {
+			events = append(events, Event{Type: TunnelRegistered, Time: now, TunnelID: up.TunnelID, Ingress: up.Ingress})
+		}
+		events = append(events, Event{Type: UplinkAdded, Time: now, TunnelID: up.TunnelID, UplinkID: up.UplinkID, RemoteAddr: up.RemoteAddr, Ingress: up.Ingress})
+	} else if up.Client == nil && ok && len(t.ups) == 1 {
+		events = append(events, Event{Type: TunnelRemoved, Time: now, TunnelID: up.TunnelID})
+	}
+	if up.Client != nil {
+		var ports map[int32]bool
+		if up.Ports != nil {
+			ports = map[int32]bool{}
+			for _, p := range up.Ports {
+				ports[p] = true
 			}
+		}
+		t.ups[up.UplinkID] = &uplinkClient{
+			client:      up.Client,
+			passthrough: up.Passthrough,
+			hostnames:   up.Hostnames,
+			balancer:    up.Balancer,
+			remoteAddr:  up.RemoteAddr,
+			registered:  now,
+			close:       up.Close,
+			ports:       ports,
+		}
+	} else {
+		delete(t.ups, up.UplinkID)
+	}
 
-			glog.Infof("now uplink map is: %v", r.m)
+	var held []NewStream
+	if len(t.ups) > 0 {
+		t.lost = time.Time{}
+		held, t.pending = t.pending, nil
+	} else if r.GraceWindow > 0 {
+		t.lost = time.Now()
+		time.AfterFunc(r.GraceWindow, func() { r.expireGrace(up.TunnelID) })
+	} else {
+		delete(s.tunnels, up.TunnelID)
+	}
+	if len(t.ups) == 0 {
+		r.forget(up.TunnelID)
+	}
 
-		case in := <-r.ingress:
-			glog.Infof("got new stream request: %v", in)
-			// poor man's round robin based on the pseudo randomization that Go runtime provides
-			// to map key iteration order.
-			// TODO(mkm) use real round-robin
-			found := false
-			for _, up := range r.m[in.TunnelID] {
-				found = true
-				hdr := tunnel.HeaderFor(in.TunnelID, in.Conn)
-				tunnel.Siphon(context.Background(), up, hdr, in.Conn)
-				break
+	old := t.hostnames
+	t.hostnames = nil
+	var ids []string
+	for id, u := range t.ups {
+		ids = append(ids, id)
+		for _, h := range u.hostnames {
+			t.hostnames = append(t.hostnames, canonicalHostname(h))
+		}
+	}
+	glog.Infof("now tunnel %q has uplinks: %q", up.TunnelID, ids)
+	s.mu.Unlock()
+
+	r.updateHostnames(up.TunnelID, old, t.hostnames)
+	for _, e := range events {
+		r.events.publish(e)
+	}
+	for _, in := range held {
+		go r.dispatch(in)
+	}
+}
+
+// expireGrace closes the streams held for a tunnel whose grace window is over.
+func (r *InProcessRouter) expireGrace(tunnelID string) {
+	s := r.shard(tunnelID)
+	s.mu.Lock()
+	t := s.tunnels[tunnelID]
+	if t == nil || len(t.ups) > 0 || t.lost.IsZero() || time.Since(t.lost) < r.GraceWindow {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.tunnels, tunnelID)
+	s.mu.Unlock()
+
+	if len(t.pending) > 0 {
+		glog.Errorf("no uplink registered for tunnel %q within %s; closing %d held streams", tunnelID, r.GraceWindow, len(t.pending))
+	}
+	for _, in := range t.pending {
+		in.Conn.Close()
+	}
+}
+
+// TunnelInfo describes a tunnel with at least one registered uplink.
+type TunnelInfo struct {
+	TunnelID string       `json:"tunnelId"`
+	Uplinks  []UplinkInfo `json:"uplinks"`
+}
+
+// UplinkInfo describes an uplink registered in the router.
+type UplinkInfo struct {
+	UplinkID      string    `json:"uplinkId"`
+	RemoteAddr    string    `json:"remoteAddr"`
+	Registered    time.Time `json:"registered"`
+	Passthrough   bool      `json:"passthrough,omitempty"`
+	Hostnames     []string  `json:"hostnames,omitempty"`
+	ActiveStreams int64     `json:"activeStreams"`
+	// BytesUp and BytesDown count the bytes received from and sent to the clients of the tunnel.
+	BytesUp   int64 `json:"bytesUp"`
+	BytesDown int64 `json:"bytesDown"`
+}
+
+// Tunnels returns the tunnels with at least one registered uplink, sorted by tunnel ID.
+func (r *InProcessRouter) Tunnels() []TunnelInfo {
+	var res []TunnelInfo
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.Lock()
+		for tid, t := range s.tunnels {
+			if len(t.ups) == 0 {
+				continue
+			}
+			ti := TunnelInfo{TunnelID: tid}
+			for id, up := range t.ups {
+				ti.Uplinks = append(ti.Uplinks, UplinkInfo{
+					UplinkID:      id,
+					RemoteAddr:    up.remoteAddr,
+					Registered:    up.registered,
+					Passthrough:   up.passthrough,
+					Hostnames:     up.hostnames,
+					ActiveStreams: atomic.LoadInt64(&up.active),
+					BytesUp:       atomic.LoadInt64(&up.bytesUp),
+					BytesDown:     atomic.LoadInt64(&up.bytesDown),
+				})
 			}
+			sort.Slice(ti.Uplinks, func(i, j int) bool { return ti.Uplinks[i].UplinkID < ti.Uplinks[j].UplinkID })
+			res = append(res, ti)
+		}
+		s.mu.Unlock()
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].TunnelID < res[j].TunnelID })
+	return res
+}
 
-			if !found {
-				glog.Errorf("cannot find any uplink for tunnel %q", in.TunnelID)
-				in.Conn.Close()
+// Disconnect forcibly disconnects an uplink of a tunnel, or all of its uplinks if uplinkID is empty,
+// and returns the number of disconnected uplinks. The uplinks are removed from the router once their
+// connections are gone.
+func (r *InProcessRouter) Disconnect(tunnelID, uplinkID string) (int, error) {
+	s := r.shard(tunnelID)
+	s.mu.Lock()
+	var closers []func()
+	if t := s.tunnels[tunnelID]; t != nil {
+		for id, up := range t.ups {
+			if (uplinkID == "" || id == uplinkID) && up.close != nil {
+				closers = append(closers, up.close)
 			}
 		}
 	}
+	s.mu.Unlock()
+
+	if len(closers) == 0 {
+		if uplinkID != "" {
+			return 0, fmt.Errorf("tunnel %q has no uplink %q", tunnelID, uplinkID)
+		}
+		return 0, fmt.Errorf("tunnel %q has no uplinks", tunnelID)
+	}
+	glog.Infof("disconnecting %d uplinks of tunnel %q", len(closers), tunnelID)
+	for _, c := range closers {
+		c()
+	}
+	return len(closers), nil
+}
+
+// runIngress sets up each new stream in its own goroutine.
+func (r *InProcessRouter) runIngress() {
+	for in := range r.ingress {
+		go func(in NewStream) {
+			glog.Infof("got new stream request: %v", in)
+			r.resolve(&in)
+			r.dispatch(in)
+		}(in)
+	}
+}
+
+// runUplink applies uplink changes in order.
+func (r *InProcessRouter) runUplink() {
+	for up := range r.uplink {
+		r.change(up)
+	}
 }
@@ -0,0 +1,377 @@
+package uplink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mkmik/udig/pkg/tunnel/tunnelpb"
+	"google.golang.org/grpc"
+)
+
+// fakeTunnelClient opens streams that accept everything sent up and end right away.
+type fakeTunnelClient struct {
+	streams int64
+}
+
+func (c *fakeTunnelClient) NewStream(ctx context.Context, opts ...grpc.CallOption) (tunnelpb.Tunnel_NewStreamClient, error) {
+	atomic.AddInt64(&c.streams, 1)
+	return fakeStream{}, nil
+}
+
+type fakeStream struct {
+	grpc.ClientStream
+}
+
+func (fakeStream) Send(*tunnelpb.Up) error       { return nil }
+func (fakeStream) Recv() (*tunnelpb.Down, error) { return nil, io.EOF }
+func (fakeStream) CloseSend() error              { return nil }
+func (fakeStream) Context() context.Context      { return context.Background() }
+
+// heldClient opens streams that stay open until ended, and reports its uplink ID on opened for each attempt.
+type heldClient struct {
+	id     string
+	opened chan<- string
+	err    error // if not nil, opening a stream fails
+
+	mu   sync.Mutex
+	open []chan struct{} // closed to end the stream
+}
+
+func newHeldClient(id string, opened chan<- string) *heldClient {
+	return &heldClient{id: id, opened: opened}
+}
+
+func (c *heldClient) NewStream(ctx context.Context, opts ...grpc.CallOption) (tunnelpb.Tunnel_NewStreamClient, error) {
+	c.opened <- c.id
+	if c.err != nil {
+		return nil, c.err
+	}
+	end := make(chan struct{})
+	c.mu.Lock()
+	c.open = append(c.open, end)
+	c.mu.Unlock()
+	return heldStream{ctx: ctx, end: end}, nil
+}
+
+// endAll ends the streams opened so far.
+func (c *heldClient) endAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, end := range c.open {
+		close(end)
+	}
+	c.open = nil
+}
+
+type heldStream struct {
+	fakeStream
+	ctx context.Context
+	end chan struct{}
+}
+
+func (s heldStream) Recv() (*tunnelpb.Down, error) {
+	select {
+	case <-s.end:
+	case <-s.ctx.Done():
+	}
+	return nil, io.EOF
+}
+
+func (s heldStream) Context() context.Context { return s.ctx }
+
+// eofConn is a client connection that has nothing to send.
+type eofConn struct {
+	net.Conn
+}
+
+func (eofConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (eofConn) Write(b []byte) (int, error) { return len(b), nil }
+func (eofConn) Close() error                { return nil }
+
+// newBenchRouter returns a router with the given number of tunnels, each with the given number of uplinks,
+// together with the tunnel IDs.
+func newBenchRouter(tunnels, uplinks int) (*InProcessRouter, []string, *fakeTunnelClient) {
+	r := NewInProcessRouter("udig.test")
+	client := &fakeTunnelClient{}
+	ids := make([]string, tunnels)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("tunnel%d", i)
+		for j := 0; j < uplinks; j++ {
+			r.change(Change{TunnelID: ids[i], UplinkID: fmt.Sprintf("uplink%d", j), Client: client})
+		}
+	}
+	return r, ids, client
+}
+
+// closedConn is an eofConn that reports when it's closed.
+type closedConn struct {
+	eofConn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newClosedConn() *closedConn {
+	return &closedConn{closed: make(chan struct{})}
+}
+
+func (c *closedConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *closedConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// nextOpened returns the uplink asked to open the next stream.
+func nextOpened(t *testing.T, opened <-chan string) string {
+	t.Helper()
+	select {
+	case id := <-opened:
+		return id
+	case <-time.After(5 * time.Second):
+		t.Fatal("no stream opened")
+		return ""
+	}
+}
+
+// waitActive waits until the uplinks of a tunnel have the wanted numbers of active streams.
+func waitActive(t *testing.T, r *InProcessRouter, tunnelID string, want map[string]int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := map[string]int64{}
+		for _, ti := range r.Tunnels() {
+			if ti.TunnelID != tunnelID {
+				continue
+			}
+			for _, u := range ti.Uplinks {
+				got[u.UplinkID] = u.ActiveStreams
+			}
+		}
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got active streams %v, want %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRouterBalancing(t *testing.T) {
+	testCases := []struct {
+		balancer string
+		// picked lists the uplinks receiving the streams: two while both uplinks are idle,
+		// then two after the streams of a ended.
+		picked []string
+		active map[string]int64
+	}{
+		{"round-robin", []string{"a", "b", "a", "b"}, map[string]int64{"a": 1, "b": 2}},
+		{"least-active", []string{"a", "b", "a", "a"}, map[string]int64{"a": 2, "b": 1}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.balancer, func(t *testing.T) {
+			r := NewInProcessRouter("udig.test")
+			var err error
+			if r.Balancer, err = NewBalancer(tc.balancer); err != nil {
+				t.Fatal(err)
+			}
+			opened := make(chan string, 10)
+			a, b := newHeldClient("a", opened), newHeldClient("b", opened)
+			r.change(Change{TunnelID: "t", UplinkID: "a", Client: a})
+			r.change(Change{TunnelID: "t", UplinkID: "b", Client: b})
+
+			var picked []string
+			dispatch := func() {
+				r.dispatch(NewStream{TunnelID: "t", Conn: eofConn{}, Header: &tunnelpb.Up_Header{}})
+				picked = append(picked, nextOpened(t, opened))
+			}
+
+			dispatch()
+			dispatch()
+			waitActive(t, r, "t", map[string]int64{"a": 1, "b": 1})
+			a.endAll()
+			waitActive(t, r, "t", map[string]int64{"a": 0, "b": 1})
+			dispatch()
+			dispatch()
+
+			if !reflect.DeepEqual(picked, tc.picked) {
+				t.Errorf("got picked uplinks %q, want %q", picked, tc.picked)
+			}
+			waitActive(t, r, "t", tc.active)
+			a.endAll()
+			b.endAll()
+			waitActive(t, r, "t", map[string]int64{"a": 0, "b": 0})
+		})
+	}
+}
+
+func TestRouterFailover(t *testing.T) {
+	r := NewInProcessRouter("udig.test")
+	opened := make(chan string, 10)
+	a, b := newHeldClient("a", opened), newHeldClient("b", opened)
+	a.err = errors.New("uplink a is gone")
+	r.change(Change{TunnelID: "t", UplinkID: "a", Client: a})
+	r.change(Change{TunnelID: "t", UplinkID: "b", Client: b})
+	defer b.endAll()
+
+	// round-robin picks a first; b takes over when a fails.
+	conn := newClosedConn()
+	r.dispatch(NewStream{TunnelID: "t", Conn: conn, Header: &tunnelpb.Up_Header{}})
+	if got, want := []string{nextOpened(t, opened), nextOpened(t, opened)}, []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got attempts on %q, want %q", got, want)
+	}
+	if conn.isClosed() {
+		t.Error("connection closed after a successful failover")
+	}
+	waitActive(t, r, "t", map[string]int64{"a": 0, "b": 1})
+
+	// a is avoided while it's unhealthy.
+	r.dispatch(NewStream{TunnelID: "t", Conn: eofConn{}, Header: &tunnelpb.Up_Header{}})
+	if got := nextOpened(t, opened); got != "b" {
+		t.Errorf("got stream on %q, want %q", got, "b")
+	}
+	waitActive(t, r, "t", map[string]int64{"a": 0, "b": 2})
+
+	// once every uplink failed, the connection is closed.
+	b.err = errors.New("uplink b is gone")
+	conn = newClosedConn()
+	r.dispatch(NewStream{TunnelID: "t", Conn: conn, Header: &tunnelpb.Up_Header{}})
+	if got, want := []string{nextOpened(t, opened), nextOpened(t, opened)}, []string{"b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got attempts on %q, want %q", got, want)
+	}
+	if !conn.isClosed() {
+		t.Error("connection not closed after all the uplinks failed")
+	}
+}
+
+func TestRouterPorts(t *testing.T) {
+	r := NewInProcessRouter("udig.test")
+	opened := make(chan string, 10)
+	a, b := newHeldClient("a", opened), newHeldClient("b", opened)
+	defer a.endAll()
+	r.change(Change{TunnelID: "t", UplinkID: "a", Client: a, Ports: []int32{443}})
+	r.change(Change{TunnelID: "t", UplinkID: "b", Client: b, Ports: []int32{}})
+
+	for i := 0; i < 2; i++ {
+		r.dispatch(NewStream{TunnelID: "t", Conn: eofConn{}, Header: &tunnelpb.Up_Header{Dport: 443}})
+		if got := nextOpened(t, opened); got != "a" {
+			t.Errorf("got stream on %q, want %q", got, "a")
+		}
+	}
+
+	// no uplink was granted the port.
+	conn := newClosedConn()
+	r.dispatch(NewStream{TunnelID: "t", Conn: conn, Header: &tunnelpb.Up_Header{Dport: 8443}})
+	if !conn.isClosed() {
+		t.Error("connection on a port no uplink was granted not closed")
+	}
+}
+
+// newLostRouter returns a router whose tunnel t just lost its only uplink.
+func newLostRouter(graceWindow time.Duration) *InProcessRouter {
+	r := NewInProcessRouter("udig.test")
+	r.GraceWindow = graceWindow
+	r.change(Change{TunnelID: "t", UplinkID: "a", Client: &fakeTunnelClient{}})
+	r.change(Change{TunnelID: "t", UplinkID: "a"})
+	return r
+}
+
+func TestRouterGraceWindow(t *testing.T) {
+	t.Run("reregister", func(t *testing.T) {
+		r := newLostRouter(time.Minute)
+		conn := newClosedConn()
+		r.dispatch(NewStream{TunnelID: "t", Conn: conn, Header: &tunnelpb.Up_Header{}})
+		if conn.isClosed() {
+			t.Fatal("held connection closed")
+		}
+
+		opened := make(chan string, 10)
+		b := newHeldClient("b", opened)
+		defer b.endAll()
+		r.change(Change{TunnelID: "t", UplinkID: "b", Client: b})
+		if got := nextOpened(t, opened); got != "b" {
+			t.Errorf("got held stream on %q, want %q", got, "b")
+		}
+		waitActive(t, r, "t", map[string]int64{"b": 1})
+		if conn.isClosed() {
+			t.Error("dispatched connection closed")
+		}
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		r := newLostRouter(50 * time.Millisecond)
+		conn := newClosedConn()
+		r.dispatch(NewStream{TunnelID: "t", Conn: conn, Header: &tunnelpb.Up_Header{}})
+		if conn.isClosed() {
+			t.Fatal("held connection closed before the grace window expired")
+		}
+		select {
+		case <-conn.closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("held connection not closed after the grace window expired")
+		}
+
+		// the tunnel is gone, so new streams aren't held anymore.
+		conn = newClosedConn()
+		r.dispatch(NewStream{TunnelID: "t", Conn: conn, Header: &tunnelpb.Up_Header{}})
+		if !conn.isClosed() {
+			t.Error("connection held after the grace window expired")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := newLostRouter(0)
+		conn := newClosedConn()
+		r.dispatch(NewStream{TunnelID: "t", Conn: conn, Header: &tunnelpb.Up_Header{}})
+		if !conn.isClosed() {
+			t.Error("connection held without a grace window")
+		}
+	})
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	for _, tunnels := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("tunnels=%d", tunnels), func(b *testing.B) {
+			r, ids, client := newBenchRouter(tunnels, 4)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r.dispatch(NewStream{TunnelID: ids[i%len(ids)], Conn: eofConn{}, Header: &tunnelpb.Up_Header{}})
+			}
+			b.StopTimer()
+			if n := atomic.LoadInt64(&client.streams); n != int64(b.N) {
+				b.Fatalf("got %d streams, want %d", n, b.N)
+			}
+		})
+	}
+}
+
+func BenchmarkDispatchParallel(b *testing.B) {
+	r, ids, client := newBenchRouter(10000, 4)
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&n, 1)
+			r.dispatch(NewStream{TunnelID: ids[int(i)%len(ids)], Conn: eofConn{}, Header: &tunnelpb.Up_Header{}})
+		}
+	})
+	b.StopTimer()
+	if got := atomic.LoadInt64(&client.streams); got != n {
+		b.Fatalf("got %d streams, want %d", got, n)
+	}
+}
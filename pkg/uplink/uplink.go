@@ -6,20 +6,36 @@ import (
 	"github.com/golang/glog"
 	"github.com/mkmik/udig/pkg/uplink/uplinkpb"
 	"golang.org/x/crypto/ed25519"
+	"google.golang.org/grpc/status"
 )
 
+// WebSocketPath is the HTTP path where the broker accepts uplinks tunneled over WebSocket.
+const WebSocketPath = "/uplink"
+
 // Server is an uplink server.
 type Server struct {
 	uplinkpb.UnimplementedUplinkServer
 	privateKey ed25519.PrivateKey
 	PublicKey  ed25519.PublicKey
 	Ports      []int32
-	sup        chan<- StatusUpdate
+	// Passthrough requests TLS passthrough ingress for the tunnel.
+	Passthrough bool
+	// Hostnames lists custom host names claimed by the tunnel (see HostnameProof).
+	Hostnames []string
+	// Balancer requests a load balancing strategy for the tunnel (see NewBalancer); if empty, the broker default is used.
+	Balancer string
+	// Token is the invite token required by private brokers.
+	Token string
+	sup   chan<- StatusUpdate
 }
 
-// StatusUpdate is used to report
+// StatusUpdate is used to report the outcome of a tunnel registration: either
+// the ingress addresses of the tunnel, the addresses of the brokers the client is
+// redirected to, or the error returned by the broker.
 type StatusUpdate struct {
-	Ingress []string
+	Ingress  []string
+	Redirect []string
+	Err      error
 }
 
 // NewServer creates a new uplink mapped on a list of ingress ports.
@@ -42,6 +58,10 @@ func (s *Server) Register(ctx context.Context, req *uplinkpb.RegisterTrigger) (*
 		Ed25519PublicKey: s.PublicKey,
 		Signature:        sig,
 		Ports:            s.Ports,
+		TlsPassthrough:   s.Passthrough,
+		Hostnames:        s.Hostnames,
+		Balancer:         s.Balancer,
+		Token:            s.Token,
 	}, nil
 }
 
@@ -49,6 +69,9 @@ func (s *Server) Register(ctx context.Context, req *uplinkpb.RegisterTrigger) (*
 func (s *Server) Setup(cxt context.Context, req *uplinkpb.SetupRequest) (*uplinkpb.SetupResponse, error) {
 	if e := req.GetError(); e != nil {
 		glog.Errorf("registration error: %s", e)
+		if s.sup != nil {
+			s.sup <- StatusUpdate{Err: status.ErrorProto(e)}
+		}
 		return &uplinkpb.SetupResponse{}, nil
 	} else if in := req.GetIngress(); in != nil {
 		glog.Infof("tunnel ingress addresses: %q", in.Ingress)
@@ -56,6 +79,12 @@ func (s *Server) Setup(cxt context.Context, req *uplinkpb.SetupRequest) (*uplink
 			s.sup <- StatusUpdate{Ingress: in.Ingress}
 		}
 		return &uplinkpb.SetupResponse{}, nil
+	} else if r := req.GetRedirect(); r != nil {
+		glog.Infof("redirected to %q", r.RedirectTo)
+		if s.sup != nil {
+			s.sup <- StatusUpdate{Redirect: r.RedirectTo}
+		}
+		return &uplinkpb.SetupResponse{}, nil
 	} else {
 		return &uplinkpb.SetupResponse{}, nil
 	}
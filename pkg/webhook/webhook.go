@@ -0,0 +1,129 @@
+// Package webhook delivers tunnel lifecycle events to HTTP endpoints.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/mkmik/udig/pkg/uplink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 signature of the request body, as "sha256=<hex>".
+	SignatureHeader = "X-Udig-Signature"
+	// EventHeader carries the event type.
+	EventHeader = "X-Udig-Event"
+
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	deliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "udig_webhook_deliveries_total",
+		Help: "Number of webhook deliveries, by result (ok, failed).",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(deliveries)
+}
+
+// Sender posts events as JSON to a webhook URL, in order.
+type Sender struct {
+	url    string
+	secret []byte
+	client *http.Client
+
+	// MaxAttempts bounds the number of delivery attempts of an event.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles after each failed attempt.
+	Backoff time.Duration
+}
+
+// NewSender creates a Sender posting to url. If secret is not empty, requests are signed with it (see Sign).
+func NewSender(url string, secret []byte) *Sender {
+	return &Sender{
+		url:         url,
+		secret:      secret,
+		client:      &http.Client{Timeout: requestTimeout},
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+	}
+}
+
+// Sign returns the signature of a request body: "sha256=" followed by the hex encoded HMAC-SHA256 of body keyed with secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run delivers the events received from events until the channel is closed.
+// Events that cannot be delivered after MaxAttempts are dropped.
+func (s *Sender) Run(events <-chan uplink.Event) {
+	for e := range events {
+		if err := s.deliver(e); err != nil {
+			deliveries.WithLabelValues("failed").Inc()
+			glog.Errorf("webhook %q: dropping %s event for tunnel %q: %v", s.url, e.Type, e.TunnelID, err)
+			continue
+		}
+		deliveries.WithLabelValues("ok").Inc()
+	}
+}
+
+// deliver posts an event, retrying with exponential backoff on network errors and on 429 and 5xx responses.
+func (s *Sender) deliver(e uplink.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	delay := s.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(e.Type, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.MaxAttempts {
+			return err
+		}
+		glog.Warningf("webhook %q: attempt %d: %v; retrying in %s", s.url, attempt, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends a request and returns whether a failure is worth retrying.
+func (s *Sender) post(typ uplink.EventType, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(typ))
+	if len(s.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(s.secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5
+	return retry, fmt.Errorf("%s", resp.Status)
+}